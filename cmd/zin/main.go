@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 	"zin-engine/engine"
 	"zin-engine/utils"
@@ -17,39 +21,141 @@ func main() {
 	// Define flags
 	port := flag.String("p", "9001", "Port to listen on")
 	rootDir := flag.String("r", "", "Root directory path")
+	grace := flag.Duration("grace", 30*time.Second, "How long to wait for active requests on shutdown")
+	certFile := flag.String("cert", "", "TLS certificate file, enables https")
+	keyFile := flag.String("key", "", "TLS private key file, enables https")
+	devTLS := flag.Bool("dev-tls", false, "Serve https with an in-memory self-signed certificate")
+	redirectPort := flag.String("redirect-port", "", "Port for a plain http listener that redirects to https")
+	trustedProxies := flag.String("trusted-proxies", engine.DefaultTrustedProxies, "Comma separated IPs or CIDR ranges allowed to set X-Root-Dir")
+	allowedRoots := flag.String("allowed-roots", "", "Comma separated directories or patterns (/var/www/*) X-Root-Dir may point at, only the -r root when empty")
 
 	// Check if -r is not provided, try to set it to current working directory
 	*rootDir = utils.GetCurrentWorkingDir(*rootDir)
 
 	// Parse command-line flags
 	flag.Parse()
-	utils.PrintASCII(*port, *rootDir, zinVersion)
 
-	// Start the engine
-	address := ":" + *port
-	listener, err := net.Listen("tcp", address)
+	// Resolve TLS configuration
+	useTLS := *devTLS || *certFile != "" || *keyFile != ""
+	if !*devTLS && useTLS && (*certFile == "" || *keyFile == "") {
+		fmt.Printf("Error starting HTTPS server:\n both -cert and -key are required")
+		return
+	}
+
+	// Proxies may also prove themselves with the secret in X-Zin-Proxy-Secret, kept out of the process list
+	trust, err := engine.ParseRootTrust(*trustedProxies, os.Getenv("ZIN_PROXY_SECRET"), *allowedRoots)
 	if err != nil {
-		fmt.Printf("Error starting TCP server:\n %v", err)
+		fmt.Printf("Error starting server:\n %v", err)
 		return
 	}
-	defer listener.Close()
-	fmt.Printf("\n✅ Listening to requests...\n")
+	engine.SetRootTrust(trust)
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	utils.PrintASCII(scheme, *port, *rootDir, zinVersion)
+
+	// Every request gets its own deadline on top of the connection context
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+
+		// Send request to engine
+		engine.HandleRequest(w, req.WithContext(ctx), *rootDir, zinVersion)
+		fmt.Println("\n<<<<----- END")
+	})
 
-	// Handle Incoming connections
-	for {
-		conn, err := listener.Accept()
+	// Keep-alive is enabled by default on http.Server
+	server := &http.Server{
+		Addr:              ":" + *port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	if *devTLS {
+		cert, err := utils.GenerateSelfSignedCert()
 		if err != nil {
-			fmt.Printf("Failed to accept: %v", err)
-			continue
+			fmt.Printf("Error starting HTTPS server:\n %v", err)
+			return
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		fmt.Printf("\n⚠️  Using a self-signed certificate, do not use -dev-tls in production\n")
+	}
+
+	// Optional plain http listener which only redirects to https
+	var redirectServer *http.Server
+	if useTLS && *redirectPort != "" {
+		redirectServer = &http.Server{
+			Addr: ":" + *redirectPort,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				engine.HandleHTTPSRedirect(w, req, *port, zinVersion)
+			}),
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       120 * time.Second,
+		}
+	}
+
+	// Listen for stop signals before accepting any connection
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// Start the engine
+	serverErr := make(chan error, 1)
+	go func() {
+		if !useTLS {
+			serverErr <- server.ListenAndServe()
+			return
 		}
+		// Certificate files are ignored when TLSConfig already holds the dev certificate
+		serverErr <- server.ListenAndServeTLS(*certFile, *keyFile)
+	}()
 
-		go func(conn net.Conn) {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
+	if redirectServer != nil {
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serverErr <- err
+			}
+		}()
+		fmt.Printf("\n↪️  Redirecting http://127.0.0.1:%s to https\n", *redirectPort)
+	}
+	fmt.Printf("\n✅ Listening to requests...\n")
 
-			// Send request to engine
-			engine.HandleConnection(ctx, conn, *rootDir, zinVersion)
-			fmt.Println("\n<<<<----- END")
-		}(conn)
+	// Wait for a stop signal or a failing listener
+	select {
+	case err := <-serverErr:
+		fmt.Printf("Error starting HTTP server:\n %v", err)
+		return
+	case sig := <-stop:
+		fmt.Printf("\n🛑 Received %s, draining active requests (up to %s)...\n", sig, *grace)
 	}
+
+	shutdown(*grace, server, redirectServer)
+}
+
+// shutdown stops accepting connections, waits for in-flight requests & releases shared resources
+func shutdown(grace time.Duration, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	exitCode := 0
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Printf("❌ Forced shutdown, active requests were cut off: %v\n", err)
+			server.Close()
+			exitCode = 1
+		}
+	}
+
+	if err := utils.CloseDB(); err != nil {
+		fmt.Printf("❌ Failed to close SQL connection: %v\n", err)
+		exitCode = 1
+	}
+
+	fmt.Printf("👋 Zin stopped.\n")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var zinCacheRegex = regexp.MustCompile(`<zin-cache\s+path="([^"]+)"\s+ttl="([^"]+)"\s*/>`)
+
+// GetRouteCacheTTL reads <zin-cache path="/blog/*" ttl="5m" /> rules from zin.config.
+// Only routes listed there have their full rendered output cached.
+func GetRouteCacheTTL(rootDir, currentPath string) (time.Duration, bool) {
+	file, err := os.Open(filepath.Join(rootDir, "zin.config"))
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		matches := zinCacheRegex.FindStringSubmatch(line)
+		if len(matches) != 3 {
+			continue
+		}
+
+		path := matches[1]
+		isMatch := path == currentPath
+		if strings.HasSuffix(path, "/*") {
+			isMatch = strings.HasPrefix(currentPath, strings.TrimSuffix(path, "*"))
+		}
+		if !isMatch {
+			continue
+		}
+
+		ttl, err := time.ParseDuration(matches[2])
+		if err != nil || ttl <= 0 {
+			return 0, false
+		}
+		return ttl, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Files the engine reads itself are never served, even without a .zinignore.
+// Names starting with an underscore (_partial.html, _data/) can be included or loaded but not routed.
+var internalFiles = map[string]bool{
+	".env":          true,
+	zinignoreFile:   true,
+	"zin.config":    true,
+	"template.html": true, // at any depth, templates are nested
+}
+
+// Directories of the root owned by the engine
+var internalDirs = []string{"modules/"}
+
+// Status pages like 404.html of the root, shown by the engine on errors
+var statusPageRegex = regexp.MustCompile(`^[1-5][0-9]{2}\.html$`)
+
+// IsInternalPath tells if a site relative path belongs to the engine and must not be routed or listed.
+// Checks ignore case, a case-insensitive file system serves /.ENV or /Modules/ from the same files,
+// and a path still holding %-escapes is checked decoded as well.
+func IsInternalPath(p string) bool {
+	if decoded, err := url.PathUnescape(p); err == nil && decoded != p && IsInternalPath(decoded) {
+		return true
+	}
+
+	p = strings.ToLower(strings.TrimPrefix(path.Clean("/"+p), "/"))
+	if p == "" {
+		return false
+	}
+
+	segments := strings.Split(p, "/")
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, "_") {
+			return true
+		}
+	}
+	if internalFiles[segments[len(segments)-1]] {
+		return true
+	}
+
+	if len(segments) == 1 && statusPageRegex.MatchString(p) {
+		return true
+	}
+	for _, dir := range internalDirs {
+		if strings.HasPrefix(p+"/", dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package config
+
+import "testing"
+
+func TestIsInternalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/index.html", false},
+		{"/blog/post.html", false},
+		{"/", false},
+		{"", false},
+		{"/4044.html", false},
+		{"/blog/404.html", false},
+		{"/my_page.html", false},
+		{"/modulesx/a.js", false},
+
+		{"/.env", true},
+		{"/.ENV", true},
+		{"/sub/.Env", true},
+		{"/.zinignore", true},
+		{"/ZIN.CONFIG", true},
+		{"/blog/Template.HTML", true},
+		{"/404.html", true},
+		{"/500.HTML", true},
+		{"/modules/x.js", true},
+		{"/Modules/x.js", true},
+		{"/MODULES", true},
+		{"/_partial.html", true},
+		{"/blog/_Data/posts.json", true},
+		{"/blog/../.env", true},
+		{"//modules//x.js", true},
+
+		// Escapes left in the path are decoded too
+		{"/%2eenv", true},
+		{"/%2Eenv", true},
+		{"/%5Fpartial.html", true},
+		{"/%4dodules/x.js", true},
+		{"/blog%2F..%2F.env", true},
+		{"/zin%2econfig", true},
+		{"/100%25.html", false},
+		{"/bad%zz.html", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsInternalPath(tt.path); got != tt.want {
+			t.Errorf("IsInternalPath(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
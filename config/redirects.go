@@ -7,15 +7,21 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"zin-engine/model"
+	"zin-engine/utils"
 )
 
+var zinRewriteRegex = regexp.MustCompile(`<zin-rewrite\s+path="([^"]+)"\s+to="([^"]+)"\s*/>`)
+
 type RouteResult struct {
 	Path string
 	Type string // "internal" or "external"
 }
 
-func GetReWriteTarget(rootDir, currentPath string) (RouteResult, error) {
-	zinConfig := filepath.Join(rootDir, "zin.config")
+// GetReWriteTarget finds the <zin-rewrite> rule of currentPath, an internal target is resolved
+// like any site file, so it can't leave the root and follows STRICT_SYMLINKS
+func GetReWriteTarget(ctx *model.RequestContext, currentPath string) (RouteResult, error) {
+	zinConfig := filepath.Join(ctx.Root, "zin.config")
 
 	// Case 1: file doesn't exist
 	if _, err := os.Stat(zinConfig); os.IsNotExist(err) {
@@ -28,7 +34,6 @@ func GetReWriteTarget(rootDir, currentPath string) (RouteResult, error) {
 	}
 	defer file.Close()
 
-	re := regexp.MustCompile(`<zin-rewrite\s+path="([^"]+)"\s+to="([^"]+)"\s*/>`)
 	scanner := bufio.NewScanner(file)
 
 	// Case 2: default to not configured
@@ -38,7 +43,7 @@ func GetReWriteTarget(rootDir, currentPath string) (RouteResult, error) {
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
-		if matches := re.FindStringSubmatch(line); len(matches) == 3 {
+		if matches := zinRewriteRegex.FindStringSubmatch(line); len(matches) == 3 {
 			path := matches[1]
 			target := matches[2]
 
@@ -49,7 +54,10 @@ func GetReWriteTarget(rootDir, currentPath string) (RouteResult, error) {
 					result.Type = "external"
 				} else {
 					result.Type = "internal"
-					result.Path = filepath.Join(rootDir, target)
+					result.Path, err = utils.ResolveSitePath(ctx, target)
+					if err != nil {
+						return RouteResult{}, err
+					}
 				}
 				break
 			}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"zin-engine/model"
+)
+
+func TestReWriteTargetStaysInRoot(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	rules := `<zin-rewrite path="/post" to="blog/post.html" />
+<zin-rewrite path="/up" to="../secret.html" />
+<zin-rewrite path="/linked" to="linked/page.html" />
+<zin-rewrite path="/away" to="https://example.com/" />`
+	if err := os.WriteFile(filepath.Join(root, "zin.config"), []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		strict string
+		want   string // empty when refused
+	}{
+		{"/post", "OFF", filepath.Join(root, "blog", "post.html")},
+		{"/up", "OFF", ""},
+		{"/linked", "OFF", filepath.Join(root, "linked", "page.html")},
+		{"/linked", "ON", ""},
+		{"/away", "ON", "https://example.com/"},
+	}
+
+	for _, tt := range tests {
+		ctx := &model.RequestContext{Root: root, ENV: map[string]string{"STRICT_SYMLINKS": tt.strict}}
+		route, err := GetReWriteTarget(ctx, tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s with STRICT_SYMLINKS=%s = %q, want it refused", tt.path, tt.strict, route.Path)
+			}
+			continue
+		}
+		if err != nil || route.Path != tt.want {
+			t.Errorf("%s = %q, %v, want %q", tt.path, route.Path, err, tt.want)
+		}
+	}
+}
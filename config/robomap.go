@@ -103,6 +103,7 @@ func loadIgnoreList(root string) []string {
 
 func writeRobotsTxt(root string, ignored []string, host string) {
 	robots := "User-agent: *\n"
+	// Internal files already answer 404, listing them here would only publish their names
 	for _, path := range ignored {
 		path = cleanFilePath(path)
 		if strings.HasSuffix(path, "/") {
@@ -185,6 +186,11 @@ func collectFiles(ctx *model.RequestContext, ignored []string) ([]string, error)
 			}
 		}
 
+		// Skip engine files, templates, status pages & _partials
+		if IsInternalPath(rel) {
+			return nil
+		}
+
 		// Skip based on ignored prefixes
 		for _, ignore := range ignored {
 			if strings.HasPrefix(rel, ignore) {
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRobotsTxtKeepsInternalNamesOut(t *testing.T) {
+	root := t.TempDir()
+	writeRobotsTxt(root, []string{"drafts/"}, "example.test")
+
+	robots, err := os.ReadFile(filepath.Join(root, robotsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"template", "zin.config", "modules", "Disallow: /_"} {
+		if strings.Contains(string(robots), name) {
+			t.Errorf("robots.txt names the internal %q:\n%s", name, robots)
+		}
+	}
+	if !strings.Contains(string(robots), "Disallow: /drafts\n") {
+		t.Errorf("robots.txt lost the .zinignore rules:\n%s", robots)
+	}
+}
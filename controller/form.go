@@ -3,6 +3,7 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,22 +16,27 @@ import (
 // Define a reasonable maximum upload size (e.g., 1 MB)
 const MAX_UPLOAD_SIZE = 1024 * 1024
 
-var defaultValidators = map[string]string{
-	"required": `.+`, // must not be empty
-	"email":    `^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`,
-	"mobile":   `^\+?[1-9]\d{9,14}$`,
+var defaultValidators = map[string]*regexp.Regexp{
+	"required": regexp.MustCompile(`.+`), // must not be empty
+	"email":    regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`),
+	"mobile":   regexp.MustCompile(`^\+?[1-9]\d{9,14}$`),
 }
 
 type formData map[string]any
 
-func HandleFormSubmission(cReq *http.Request, ctx *model.RequestContext) (int, string) {
-	// Read post body
+func HandleFormSubmission(w http.ResponseWriter, cReq *http.Request, ctx *model.RequestContext) (int, string) {
+	// Read post body, up to MAX_UPLOAD_SIZE
+	cReq.Body = http.MaxBytesReader(w, cReq.Body, MAX_UPLOAD_SIZE)
+	defer cReq.Body.Close()
+
 	body, err := io.ReadAll(cReq.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return 413, fmt.Sprintf(`{"error":"Form content is larger than %d bytes."}`, MAX_UPLOAD_SIZE)
+	}
 	if err != nil {
 		return 400, `{"error":"Failed to read form content."}`
-
 	}
-	defer cReq.Body.Close()
 
 	if len(body) == 0 {
 		return 400, `{"error":"Form content is empty."}`
@@ -51,27 +57,21 @@ func HandleFormSubmission(cReq *http.Request, ctx *model.RequestContext) (int, s
 		return 404, `{"error":"Form submission invalid: data was tampered with or not from a valid ZinForm."}`
 	}
 
-	// Validate Session
-	sessionData, err := utils.Decrypt(zinFormSession, zinFormId)
+	// Validate Session, the token is sealed with the site secret for this very form
+	session, err := utils.OpenFormToken(ctx, zinFormId, zinFormSession)
 	if err != nil {
 		return 401, fmt.Sprintf(`{"error":"%v"}`, err)
 	}
 
-	// Extract form submission link form session data
-	zinFormSessionData := strings.Split(sessionData, "::")
-	if len(zinFormSessionData) != 5 || zinFormSessionData[2] != ctx.ClientIp {
-		return 401, `{"error":"Form session token is tempered or expired, try again"}`
-	}
-
 	// Validate inputs submitted by client
-	validInputs := validateInputs(zinFormSessionData[4], formData)
+	validInputs := validateInputs(session.Validators, formData)
 	if validInputs != nil {
 		return 401, fmt.Sprintf(`{"error":"Validation Error: %v"}`, validInputs)
 	}
 
 	// Check if captcha-verification is applicable
-	zinFormURL := zinFormSessionData[0]
-	zinFormValidatorService := zinFormSessionData[3]
+	zinFormURL := session.Action
+	zinFormValidatorService := session.Captcha
 	if zinFormValidatorService == "GOOGLE" {
 		recaptchaSecret := utils.GetValue(ctx, "GOOGLE_RECAPTCHA_SECRET", "", true)
 		if recaptchaSecret != "" {
@@ -88,6 +88,18 @@ func HandleFormSubmission(cReq *http.Request, ctx *model.RequestContext) (int, s
 		}
 	}
 
+	// A token is good for one submission, claimed only now so invalid input can be corrected & sent again.
+	// Claiming before the forward keeps parallel replays out, a failed forward hands the token back.
+	if !utils.ConsumeFormToken(session) {
+		return 409, fmt.Sprintf(`{"error":"%v"}`, utils.ErrFormTokenUsed)
+	}
+	forwarded := false
+	defer func() {
+		if !forwarded {
+			utils.ReleaseFormToken(session)
+		}
+	}()
+
 	// Remove form-defaults from form payload
 	delete(formData, "zinFormId")
 	delete(formData, "zinFormSession")
@@ -96,7 +108,7 @@ func HandleFormSubmission(cReq *http.Request, ctx *model.RequestContext) (int, s
 
 	// Forward form data to configured endpoint
 	formPayload, _ := json.Marshal(formData)
-	req, err := http.NewRequest("POST", zinFormURL, bytes.NewBuffer(formPayload))
+	req, err := http.NewRequestWithContext(utils.RequestContext(ctx), "POST", zinFormURL, bytes.NewBuffer(formPayload))
 	if err != nil {
 		return 500, fmt.Sprintf(`{"error":"%v"}`, err)
 	}
@@ -107,8 +119,11 @@ func HandleFormSubmission(cReq *http.Request, ctx *model.RequestContext) (int, s
 	req.Header.Set("X-ZIN-Ref", zinFormId)
 	req.Header.Set("X-ZIN-Validator", zinFormValidatorService)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// The action goes through the outbound policy of the site, like http zin-data sources
+	resp, err := utils.OutboundClient(ctx).Do(req)
+	if errors.Is(err, utils.ErrOutboundBlocked) {
+		return 403, `{"error":"Form action is blocked by the outbound policy of the site."}`
+	}
 	if err != nil {
 		return 502, fmt.Sprintf(`{"error":"%v"}`, err)
 	}
@@ -116,6 +131,7 @@ func HandleFormSubmission(cReq *http.Request, ctx *model.RequestContext) (int, s
 
 	respBody, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		forwarded = true
 		return 200, `{"message":"Form submitted successfully"}`
 	}
 
@@ -179,13 +195,12 @@ func validateInputs(validator string, data formData) error {
 		for _, rule := range ruleParts {
 			rule = strings.TrimSpace(rule)
 
-			regexStr, err := getValidatorRegex(rule)
+			validator, err := getValidatorRegex(rule)
 			if err != nil {
 				return err
 			}
 
-			matched, _ := regexp.MatchString(regexStr, valStr)
-			if !matched {
+			if !validator.MatchString(valStr) {
 				if rule == "required" && valStr == "" {
 					return fmt.Errorf("input field '%s' is required and cannot be blank", field)
 				}
@@ -198,11 +213,11 @@ func validateInputs(validator string, data formData) error {
 }
 
 // Function to get regex from validator key, supporting zin.somekey
-func getValidatorRegex(key string) (string, error) {
+func getValidatorRegex(key string) (*regexp.Regexp, error) {
 
 	if val, ok := defaultValidators[key]; ok {
 		return val, nil
 	}
 
-	return "", fmt.Errorf("validator not found: %s", key)
+	return nil, fmt.Errorf("validator not found: %s", key)
 }
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"zin-engine/model"
+	"zin-engine/utils"
+)
+
+func formContext(t *testing.T) *model.RequestContext {
+	t.Helper()
+	return &model.RequestContext{
+		Context:       context.Background(),
+		ClientIp:      "203.0.113.7",
+		Root:          t.TempDir(),
+		ServerVersion: "zin/test",
+		ENV: map[string]string{
+			"FORM_SECRET":            "0123456789abcdef0123456789abcdef",
+			"OUTBOUND_ALLOW_PRIVATE": "ON",
+		},
+	}
+}
+
+func submit(ctx *model.RequestContext, body string) (int, string) {
+	req := httptest.NewRequest("POST", "/zin-form", strings.NewReader(body))
+	return HandleFormSubmission(httptest.NewRecorder(), req, ctx)
+}
+
+func TestFailedForwardKeepsToken(t *testing.T) {
+	var status atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer api.Close()
+
+	ctx := formContext(t)
+	sealed, err := utils.SealFormToken(ctx, "contact", utils.FormToken{Action: api.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"zinFormId": "contact", "zinFormSession": sealed, "zinFormSource": "/", "name": "Ann"})
+
+	tests := []struct {
+		status int32
+		want   int
+	}{
+		{500, 500}, // endpoint fails, the visitor may send again
+		{503, 503},
+		{200, 200},
+		{200, 409}, // spent by the submission that went through
+	}
+	for i, tt := range tests {
+		status.Store(tt.status)
+		if got, content := submit(ctx, string(body)); got != tt.want {
+			t.Errorf("submission %d: status %d %s, want %d", i+1, got, content, tt.want)
+		}
+	}
+}
+
+func TestFormBodyIsLimited(t *testing.T) {
+	ctx := formContext(t)
+	body := `{"zinFormId":"contact","message":"` + strings.Repeat("x", MAX_UPLOAD_SIZE) + `"}`
+	if got, content := submit(ctx, body); got != 413 {
+		t.Errorf("oversized form: status %d %s, want 413", got, content)
+	}
+}
@@ -0,0 +1,176 @@
+package directives
+
+import (
+	"strings"
+	"zin-engine/model"
+)
+
+// Pos is the location of a node in the composed page, Line & Col start at 1
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (p Pos) Position() Pos {
+	return p
+}
+
+// Node is a piece of the parsed page: plain text, a {{ }} expression or a zin element
+type Node interface {
+	Position() Pos
+}
+
+// TextNode is content the engine doesn't interpret, HTML included
+type TextNode struct {
+	Pos
+	Text string
+}
+
+// ExprNode is a {{ ... }} placeholder, Expr holds the trimmed inside
+type ExprNode struct {
+	Pos
+	Expr    string
+	Raw     string
+	Context escapeContext // where the value is printed, decides how it is escaped
+}
+
+// Attr is a single attribute of a zin element, Value has quotes removed
+type Attr struct {
+	Pos
+	Name  string
+	Value string
+}
+
+// ElementNode is a <zin-*> tag, block tags like zin-repeat keep their children
+type ElementNode struct {
+	Pos
+	Name     string
+	Attrs    []Attr
+	Children []Node
+	Raw      string // opening tag as written
+	CloseRaw string // closing tag as written, empty for void tags
+	Err      string // set when the tag is malformed e.g. a missing closing tag
+
+	scope    *Scope  // loop scope the element was repeated in, for directives that evaluate expressions themselves
+	deferred []Phase // phases held back from the body of a zin-if branch until it is chosen
+}
+
+// Directive renders a single zin element into the nodes that replace it
+type Directive func(el *ElementNode, ctx *model.RequestContext) []Node
+
+// Attr returns the value of the first attribute with the given name
+func (el *ElementNode) Attr(name string) (string, bool) {
+	for _, attr := range el.Attrs {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// AttrMap returns all attributes by name, later duplicates win
+func (el *ElementNode) AttrMap() map[string]string {
+	attrs := make(map[string]string, len(el.Attrs))
+	for _, attr := range el.Attrs {
+		attrs[attr.Name] = attr.Value
+	}
+	return attrs
+}
+
+// replaceVariables resolves the {{ }} of an attribute value once, inside a loop with the scope of the pass
+func (el *ElementNode) replaceVariables(content string, ctx *model.RequestContext) string {
+	scope := el.scope
+	if scope == nil {
+		scope = newScope(ctx)
+	}
+	return replaceScopeVariables(content, scope)
+}
+
+// mapStrings rewrites the opening tag and every attribute value with fn
+func (el *ElementNode) mapStrings(fn func(string) string) {
+	el.Raw = fn(el.Raw)
+	for i := range el.Attrs {
+		el.Attrs[i].Value = fn(el.Attrs[i].Value)
+	}
+}
+
+// Render turns nodes back into page content, unprocessed nodes are written as found
+func Render(nodes []Node) string {
+	var builder strings.Builder
+	renderTo(&builder, nodes)
+	return builder.String()
+}
+
+func renderTo(builder *strings.Builder, nodes []Node) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			builder.WriteString(n.Text)
+		case *ExprNode:
+			builder.WriteString(n.Raw)
+		case *ElementNode:
+			builder.WriteString(n.Raw)
+			renderTo(builder, n.Children)
+			builder.WriteString(n.CloseRaw)
+		}
+	}
+}
+
+// cloneNodes deep copies nodes so each loop iteration can rewrite its own body
+func cloneNodes(nodes []Node) []Node {
+	out := make([]Node, len(nodes))
+	for i, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			c := *n
+			out[i] = &c
+		case *ExprNode:
+			c := *n
+			out[i] = &c
+		case *ElementNode:
+			c := *n
+			c.Attrs = append([]Attr(nil), n.Attrs...)
+			c.deferred = append([]Phase(nil), n.deferred...)
+			c.Children = cloneNodes(n.Children)
+			out[i] = &c
+		}
+	}
+	return out
+}
+
+// textNodes wraps directive output into a node list
+func textNodes(text string) []Node {
+	if text == "" {
+		return nil
+	}
+	return []Node{&TextNode{Text: text}}
+}
+
+// applyElementPhase replaces every element called name, at any depth, with the directive output
+func applyElementPhase(nodes []Node, ctx *model.RequestContext, name string, directive Directive) []Node {
+	out := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		el, ok := node.(*ElementNode)
+		if !ok {
+			out = append(out, node)
+			continue
+		}
+
+		if el.Name == name {
+			out = append(out, directive(el, ctx)...)
+			continue
+		}
+
+		// Nothing runs inside a condition before it is evaluated, the chosen branch catches up then
+		if isBranch(el.Name) {
+			el.deferred = append(el.deferred, elementPhase(name, directive))
+			out = append(out, el)
+			continue
+		}
+
+		el.Children = applyElementPhase(el.Children, ctx, name, directive)
+		out = append(out, el)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package directives
+
+import (
+	"fmt"
+	"strings"
+	"zin-engine/model"
+)
+
+var ifTagExample = `<zin-if cond="user.role == 'admin'">...</zin-if><zin-elseif cond="empty(posts)">...</zin-elseif><zin-else>...</zin-else>`
+
+// ConditionalDirective keeps the first zin-if / zin-elseif / zin-else branch whose condition holds.
+// Bodies of zin-repeat are skipped, the loop evaluates them once per item.
+func ConditionalDirective(nodes []Node, ctx *model.RequestContext) []Node {
+	return applyConditionals(nodes, newScope(ctx))
+}
+
+func applyConditionals(nodes []Node, scope *Scope) []Node {
+	out := make([]Node, 0, len(nodes))
+	for i := 0; i < len(nodes); i++ {
+		el, ok := nodes[i].(*ElementNode)
+		if !ok {
+			out = append(out, nodes[i])
+			continue
+		}
+
+		switch el.Name {
+		case "zin-if":
+			branch, next := chooseBranch(nodes, i, scope)
+			out = append(out, applyConditionals(branch, scope)...)
+			i = next - 1
+		case "zin-elseif", "zin-else":
+			out = append(out, orphanBranchError(el, scope.ctx)...)
+		case "zin-repeat":
+			out = append(out, el)
+		default:
+			el.Children = applyConditionals(el.Children, scope)
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// chooseBranch evaluates the chain starting at nodes[start] and returns the children to keep
+// and the index right after the chain. Only whitespace may separate the branches.
+func chooseBranch(nodes []Node, start int, scope *Scope) ([]Node, int) {
+	chain := []*ElementNode{nodes[start].(*ElementNode)}
+	next := start + 1
+	for j := start + 1; j < len(nodes); j++ {
+		if t, ok := nodes[j].(*TextNode); ok && strings.TrimSpace(t.Text) == "" {
+			continue
+		}
+		el, ok := nodes[j].(*ElementNode)
+		if !ok || (el.Name != "zin-elseif" && el.Name != "zin-else") || chain[len(chain)-1].Name == "zin-else" {
+			break
+		}
+		chain = append(chain, el)
+		next = j + 1
+	}
+
+	for _, el := range chain {
+		if el.Err != "" {
+			return inlineError(scope.ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), el.Err), next
+		}
+		if el.Name == "zin-else" {
+			return runDeferred(el, scope.ctx), next
+		}
+
+		matched, err := evalCondition(el, scope)
+		if err != nil {
+			return inlineError(scope.ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), err.Error()), next
+		}
+		if matched {
+			return runDeferred(el, scope.ctx), next
+		}
+	}
+	return nil, next
+}
+
+// runDeferred applies the phases skipped while the branch was undecided to its body
+func runDeferred(el *ElementNode, ctx *model.RequestContext) []Node {
+	nodes := el.Children
+	for _, phase := range el.deferred {
+		if len(ctx.ServerError) > 0 {
+			break
+		}
+		nodes = phase(nodes, ctx)
+	}
+	return nodes
+}
+
+func isBranch(name string) bool {
+	return name == "zin-if" || name == "zin-elseif" || name == "zin-else"
+}
+
+// evalCondition parses and evaluates the cond attribute of a zin-if or zin-elseif
+func evalCondition(el *ElementNode, scope *Scope) (bool, error) {
+	cond, ok := el.Attr("cond")
+	if !ok || strings.TrimSpace(cond) == "" {
+		return false, fmt.Errorf("The <%s> tag needs a 'cond' attribute. Example: %s", el.Name, ifTagExample)
+	}
+
+	expr, condScope, err := parseAttrExpr(cond, scope)
+	if err != nil {
+		return false, fmt.Errorf("Invalid condition '%s': %v", cond, err)
+	}
+	val, err := expr.eval(condScope)
+	if err != nil {
+		return false, fmt.Errorf("Invalid condition '%s': %v", cond, err)
+	}
+	return truthy(val), nil
+}
+
+func orphanBranchError(el *ElementNode, ctx *model.RequestContext) []Node {
+	return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("<%s> must directly follow a </zin-if> or </zin-elseif>. Example: %s", el.Name, ifTagExample))
+}
@@ -0,0 +1,49 @@
+package directives
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUntakenBranchHasNoSideEffects(t *testing.T) {
+	var hits atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"` + r.URL.Query().Get("branch") + `"}`))
+	}))
+	defer api.Close()
+
+	tests := []struct {
+		order string
+		page  string
+		want  string
+		hits  int32
+	}{
+		{"pipeline", `<zin-if cond="mode == 'on'"><zin-data src="` + api.URL + `/?branch=if" as="r" /><zin-set key="seen" value="if" /></zin-if><zin-elseif cond="mode == 'off'"><zin-data src="` + api.URL + `/?branch=elseif" as="r" /></zin-elseif><zin-else><zin-set key="seen" value="else" /></zin-else>[{{ r.name }}|{{ seen }}]`, "[elseif|undefined]", 1},
+		{"pipeline", `<zin-if cond="false"><zin-data src="` + api.URL + `/?branch=if" as="r" /></zin-if>`, "", 0},
+		{"pipeline", `<zin-if cond="false"><zin-repeat for="items"><zin-data src="` + api.URL + `/?branch=loop" as="r" /></zin-repeat></zin-if>`, "", 0},
+		{"pipeline", `<zin-repeat for="items" as="i"><zin-if cond="i > 1"><zin-data src="` + api.URL + `/?branch={{ i }}" as="r" /></zin-if></zin-repeat>`, "", 2},
+		{"pipeline", `<zin-if cond="true"><zin-if cond="false"><zin-data src="` + api.URL + `/?branch=inner" as="r" /></zin-if>[{{ seen || "none" }}]</zin-if>`, "[none]", 0},
+		{"document", `<zin-if cond="mode == 'on'"><zin-data src="` + api.URL + `/?branch=if" as="r" /></zin-if><zin-else><zin-set key="seen" value="else" /></zin-else>[{{ seen }}]`, "[else]", 0},
+	}
+
+	for _, tt := range tests {
+		hits.Store(0)
+		ctx := testContext(t, t.TempDir(), "mode=off")
+		ctx.ENV["OUTBOUND_ALLOW_PRIVATE"] = "ON"
+		ctx.ENV["DIRECTIVE_ORDER"] = tt.order
+		ctx.CustomVar.LIST["items"] = []any{1.0, 2.0, 3.0}
+
+		got := strings.TrimSpace(ParseAndApply(tt.page, ctx))
+		if got != tt.want {
+			t.Errorf("%s: %s = %q, want %q", tt.order, tt.page, got, tt.want)
+		}
+		if n := hits.Load(); n != tt.hits {
+			t.Errorf("%s: %s called the API %d times, want %d", tt.order, tt.page, n, tt.hits)
+		}
+	}
+}
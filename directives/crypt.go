@@ -2,54 +2,42 @@ package directives
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 	"zin-engine/model"
 	"zin-engine/utils"
 )
 
-func CryptDirective(content string, ctx *model.RequestContext) string {
+func cryptDirective(el *ElementNode, ctx *model.RequestContext) []Node {
+	zinCryptAttr := el.AttrMap()
 
-	// No hash directive, return unchanged
-	if !strings.Contains(content, "<zin-crypt") {
-		return content
+	action, ok := zinCryptAttr["action"]
+	if !ok {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), "Missing required attribute: 'action' in zin-crypt tag.")
 	}
 
-	zinHashRegex := regexp.MustCompile(`<zin-crypt\s+([^>]+)\s*/?>`)
-	content = zinHashRegex.ReplaceAllStringFunc(content, func(tag string) string {
-		zinCryptAttr := utils.ExtractAttributesFromTag(tag)
-
-		action, ok := zinCryptAttr["action"]
-		if !ok {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), "Missing required attribute: 'action' in zin-crypt tag.")
-		}
-
-		action = strings.ToUpper(action)
-		if action == "HASH" {
-			value, err := composeHash(ctx, zinCryptAttr)
-			if err != nil {
-				return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Unable to compose hash: %v", err))
-			}
-
-			return value
+	action = strings.ToUpper(action)
+	if action == "HASH" {
+		value, err := composeHash(ctx, el, zinCryptAttr)
+		if err != nil {
+			return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Unable to compose hash: %v", err))
 		}
 
-		if action == "ENCRYPT" || action == "DECRYPT" || action == "ENC" || action == "DEC" {
-			value, err := encodeDecodeValue(ctx, zinCryptAttr, action)
-			if err != nil {
-				return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Unable to '%s' given data. Error: %v", strings.ToLower(action), err))
-			}
+		return textNodes(value)
+	}
 
-			return value
+	if action == "ENCRYPT" || action == "DECRYPT" || action == "ENC" || action == "DEC" {
+		value, err := encodeDecodeValue(ctx, el, zinCryptAttr, action)
+		if err != nil {
+			return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Unable to '%s' given data. Error: %v", strings.ToLower(action), err))
 		}
 
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Given action for zin-crypt '%s' is not supported. You can performs actions like encrypt, decrypt & hash", action))
-	})
+		return textNodes(value)
+	}
 
-	return content
+	return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Given action for zin-crypt '%s' is not supported. You can performs actions like encrypt, decrypt & hash", action))
 }
 
-func composeHash(ctx *model.RequestContext, attr map[string]string) (string, error) {
+func composeHash(ctx *model.RequestContext, el *ElementNode, attr map[string]string) (string, error) {
 
 	algorithm, ok1 := attr["algorithm"]
 	data, ok2 := attr["data"]
@@ -77,8 +65,8 @@ func composeHash(ctx *model.RequestContext, attr map[string]string) (string, err
 	}
 
 	// Put variable-values if needed
-	salt = ReplaceVariables(salt, ctx)
-	data = ReplaceVariables(data, ctx)
+	salt = el.replaceVariables(salt, ctx)
+	data = el.replaceVariables(data, ctx)
 
 	algorithm = strings.ToLower(algorithm)
 	data = data + salt
@@ -91,7 +79,7 @@ func composeHash(ctx *model.RequestContext, attr map[string]string) (string, err
 	return result, nil
 }
 
-func encodeDecodeValue(ctx *model.RequestContext, attr map[string]string, action string) (string, error) {
+func encodeDecodeValue(ctx *model.RequestContext, el *ElementNode, attr map[string]string, action string) (string, error) {
 
 	data, ok1 := attr["data"]
 	key, ok2 := attr["key"]
@@ -105,8 +93,8 @@ func encodeDecodeValue(ctx *model.RequestContext, attr map[string]string, action
 	}
 
 	// Put variable-values if needed
-	key = ReplaceVariables(key, ctx)
-	data = ReplaceVariables(data, ctx)
+	key = el.replaceVariables(key, ctx)
+	data = el.replaceVariables(data, ctx)
 
 	// Check if action is to encrypt the data
 	if action == "ENC" || action == "ENCRYPT" {
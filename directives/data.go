@@ -6,85 +6,86 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"zin-engine/model"
 	"zin-engine/utils"
 )
 
-// Regex to match <zin-data src="..." as="..."/>
-var (
-	zinDataRegex = regexp.MustCompile(`<zin-data\s+src="([^"]+)"\s+as="([^"]+)"\s*/?>`)
-	zinDataTag   = `<zin-data src="file://path/to/file.exe" into="varName" />`
-)
+var zinDataTag = `<zin-data src="file://path/to/file.json" as="varName" />`
 
-// ProcessZinDataTags validates and rewrites <zin-data> tags
-func DataDirectives(content string, ctx *model.RequestContext) string {
-	// No data directive, return unchanged
-	if !strings.Contains(content, "<zin-data") {
-		return content
+// dataDirective loads data from the source into a variable
+func dataDirective(el *ElementNode, ctx *model.RequestContext) []Node {
+	tag := el.Raw
+	src, hasSrc := el.Attr("src")
+	varName, hasAs := el.Attr("as")
+	if !hasSrc || !hasAs || src == "" || varName == "" {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Invalid zin-data tag format. Example: %s", zinDataTag))
 	}
 
-	// Change all
-	return zinDataRegex.ReplaceAllStringFunc(content, func(tag string) string {
-		matches := zinDataRegex.FindStringSubmatch(tag)
-		if len(matches) < 3 {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Invalid zin-data tag format. Example: %s", zinDataTag))
-		}
+	parts := strings.SplitN(src, "://", 2)
 
-		src := matches[1]
-		varName := matches[2]
-		parts := strings.SplitN(src, "://", 2)
+	// Check if src has operator defined
+	if len(parts) != 2 {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Invalid 'src' value. It must start with a supported operator type such as 'file:', 'sql:', 'http:', 'https:', or 'sheets:'. Example: %s", zinDataTag))
+	}
 
-		// Check if src has operator defined
-		if len(parts) != 2 {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Invalid 'src' value. It must start with a supported operator type such as 'file:', 'sql:', 'http:', 'https:', or 'sheets:'. Example: %s", zinDataTag))
-		}
+	// import data into var from local file
+	if parts[0] == "file" {
+		return textNodes(importDataFromLocalFile(ctx, parts[1], varName, tag))
+	}
 
-		// import data into var from local file
-		if parts[0] == "file" {
-			return importDataFromLocalFile(ctx, parts[1], varName, tag)
-		}
+	// import data from google-sheets using google visualization api
+	if parts[0] == "sheets" {
+		return textNodes(importDataFromGoogleSheets(ctx, el, parts[1], varName))
+	}
 
-		// import data from google-sheets using google visualization api
-		if parts[0] == "sheets" {
-			return importDataFromGoogleSheets(ctx, parts[1], varName, tag)
-		}
+	// import data from external api over http using google visualization api
+	if parts[0] == "http" || parts[0] == "https" {
+		return textNodes(importDataFromExternalAPI(ctx, el, src, varName))
+	}
 
-		// import data from external api over http using google visualization api
-		if parts[0] == "http" || parts[0] == "https" {
-			return importDataFromExternalAPI(ctx, src, varName, tag)
+	// import data from a named sql connection, sql://name/SELECT ...
+	if parts[0] == "sql" {
+		name, query, ok := strings.Cut(parts[1], "/")
+		if !ok || name == "" {
+			return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), `Invalid 'src' value. A sql source names its connection first, e.g. sql://main/SELECT * FROM posts`)
 		}
+		return textNodes(importDataFromSQL(ctx, el, name, query, varName))
+	}
 
-		// import data from mysql-database
-		if parts[0] == "mysql" {
-			return importDataFromMySQL(ctx, parts[1], varName, tag)
-		}
+	// import data from mysql-database
+	if parts[0] == "mysql" {
+		return textNodes(importDataFromSQL(ctx, el, utils.LegacyMySQL, parts[1], varName))
+	}
 
-		// Done
-		return ""
-	})
+	// Done
+	return nil
 }
 
 func importDataFromLocalFile(ctx *model.RequestContext, src string, varName string, tag string) string {
 
-	fullPath := filepath.Join(ctx.Root, src)
+	fullPath, err := utils.ResolveSitePath(ctx, src)
+	if err != nil {
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Refused to load data source '%s': %v", src, err))
+	}
 
 	// Check if file exists
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Data source file not found (%s)", src))
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Data source file not found (%s)", src))
 	}
 
 	// Validate file extension
 	ext := strings.ToLower(filepath.Ext(src))
 	if ext != ".json" && ext != ".csv" {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Only .json or .csv files are supported for data loading. Invalid file: %s", src))
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Only .json or .csv files are supported for data loading. Invalid file: %s", src))
 	}
 
 	// Extract data from file
 	data, err := os.ReadFile(fullPath)
 	if err != nil {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Failed to read contents of file: %s. Error: %v", fullPath, err))
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Failed to read contents of file: %s. Error: %v", fullPath, err))
 	}
 
 	// Set data into var
@@ -93,13 +94,13 @@ func importDataFromLocalFile(ctx *model.RequestContext, src string, varName stri
 	if ext == ".json" {
 		err = json.Unmarshal(data, &parsed)
 		if err != nil {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Failed to parse JSON content of file: %s. Error: %v", fullPath, err))
+			return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Failed to parse JSON content of file: %s. Error: %v", fullPath, err))
 		}
 	} else if ext == ".csv" {
 		reader := csv.NewReader(strings.NewReader(string(data)))
 		records, err := reader.ReadAll()
 		if err != nil || len(records) < 1 {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Failed to parse CSV content of file: %s. Error: %v", fullPath, err))
+			return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Failed to parse CSV content of file: %s. Error: %v", fullPath, err))
 		}
 
 		headers := records[0]
@@ -149,61 +150,175 @@ func importDataFromLocalFile(ctx *model.RequestContext, src string, varName stri
 	return ""
 }
 
-func importDataFromGoogleSheets(ctx *model.RequestContext, src string, varName string, tag string) string {
+func importDataFromGoogleSheets(ctx *model.RequestContext, el *ElementNode, src string, varName string) string {
+	tag := el.Raw
 
 	// Replace all vars with actual values
-	src = ReplaceVariables(src, ctx)
+	src = el.replaceVariables(src, ctx)
 
 	// Parse src to get sheet Name, Id & query separately
 	result, err := utils.ParseSheetQuery(src)
 	if err != nil {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Error: %v", err))
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Error: %v", err))
 
 	}
 
 	// Encode it to run over http
 	result.Query = utils.EncodeSheetsQuery(result.Query)
 	if result.Query == "" {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), "Error: You can only run 'SELECT' query to fetch data from google sheets.")
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), "Error: You can only run 'SELECT' query to fetch data from google sheets.")
 	}
 
-	// Fetch data from sheets
-	response := utils.FetchDataFromSheets(result.SheetID, result.SheetName, result.Query)
-	if strings.Contains(response, "Error: ") {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), response)
-	}
-
-	// Set data to context list for later use
-	err = utils.CsvToContextList(ctx, varName, response)
+	// Fetch data from sheets & set it to context list for later use
+	err = loadDataSource(ctx, el, "sheets://"+src, varName, func(c *model.RequestContext) error {
+		response := utils.FetchDataFromSheets(result.SheetID, result.SheetName, result.Query)
+		if strings.Contains(response, "Error: ") {
+			return fmt.Errorf("%s", response)
+		}
+		return utils.CsvToContextList(c, varName, response)
+	})
 	if err != nil {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), err.Error())
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), err.Error())
 	}
 
 	// Done
 	return ""
 }
 
-func importDataFromExternalAPI(ctx *model.RequestContext, src string, varName string, tag string) string {
+func importDataFromExternalAPI(ctx *model.RequestContext, el *ElementNode, src string, varName string) string {
+	tag := el.Raw
+
 	// Replace all vars with actual values
-	src = ReplaceVariables(src, ctx)
+	src = el.replaceVariables(src, ctx)
+
+	opts, err := httpOptions(ctx, el)
+	if err != nil {
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), err.Error())
+	}
 
-	// Call given endpoint to fetch data
-	err := utils.Get(ctx, src, varName)
+	// Call given endpoint to fetch data, cached responses are keyed by everything sent.
+	// Credentials are in as a hash, so a changed secret never serves the old response.
+	headers, _ := el.Attr("headers")
+	key := fmt.Sprintf("%s %s|%s|%s|%s", opts.Method, src, opts.Body, el.replaceVariables(headers, ctx), utils.AuthFingerprint(ctx, opts.Auth))
+	err = loadDataSource(ctx, el, key, varName, func(c *model.RequestContext) error {
+		return utils.Fetch(c, src, varName, opts)
+	})
 	if err != nil {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), err.Error())
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), err.Error())
 	}
 
 	return ""
 }
 
-func importDataFromMySQL(ctx *model.RequestContext, src string, varName string, tag string) string {
-	// Replace all vars with actual values
-	src = ReplaceVariables(src, ctx)
+var httpMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// httpOptions reads method, body, headers, auth, timeout & max-bytes of an http(s) zin-data
+func httpOptions(ctx *model.RequestContext, el *ElementNode) (utils.HTTPOptions, error) {
+	opts := utils.HTTPOptions{Method: "GET", Timeout: utils.DefaultHTTPTimeout, MaxBytes: utils.DefaultHTTPMaxBytes}
+
+	if method, ok := el.Attr("method"); ok {
+		opts.Method = strings.ToUpper(strings.TrimSpace(method))
+		if !httpMethods[opts.Method] {
+			return opts, fmt.Errorf("Invalid 'method' value '%s'. Use GET, POST, PUT, PATCH or DELETE", method)
+		}
+	}
+
+	if body, ok := el.Attr("body"); ok {
+		opts.Body = el.replaceVariables(body, ctx)
+	}
 
-	// Call given endpoint to fetch data
-	err := utils.RunQuery(ctx, src, varName)
+	// headers="Accept: application/json | X-Api-Version: 2"
+	if headers, ok := el.Attr("headers"); ok {
+		opts.Headers = make(map[string]string)
+		for _, header := range strings.Split(el.replaceVariables(headers, ctx), "|") {
+			if strings.TrimSpace(header) == "" {
+				continue
+			}
+			name, value, found := strings.Cut(header, ":")
+			name = strings.TrimSpace(name)
+			if !found || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+				return opts, fmt.Errorf("Invalid header '%s'. Headers are written as \"Name: value | Name: value\"", strings.TrimSpace(header))
+			}
+			opts.Headers[name] = strings.TrimSpace(value)
+		}
+	}
+
+	if auth, ok := el.Attr("auth"); ok {
+		opts.Auth = strings.TrimSpace(auth)
+	}
+
+	if raw, ok := el.Attr("timeout"); ok {
+		timeout, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil || timeout <= 0 {
+			return opts, fmt.Errorf("Invalid 'timeout' value '%s'. Use a duration such as 500ms or 5s", raw)
+		}
+		opts.Timeout = timeout
+	}
+
+	if raw, ok := el.Attr("max-bytes"); ok {
+		maxBytes, err := parseByteSize(raw)
+		if err != nil || maxBytes < 1 {
+			return opts, fmt.Errorf("Invalid 'max-bytes' value '%s'. Use a size such as 65536, 512KB or 2MB", raw)
+		}
+		opts.MaxBytes = maxBytes
+	}
+
+	return opts, nil
+}
+
+// parseByteSize reads a byte count with an optional KB or MB suffix
+func parseByteSize(raw string) (int64, error) {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(raw, "KB"):
+		unit, raw = 1<<10, strings.TrimSuffix(raw, "KB")
+	case strings.HasSuffix(raw, "MB"):
+		unit, raw = 1<<20, strings.TrimSuffix(raw, "MB")
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	return n * unit, err
+}
+
+func importDataFromSQL(ctx *model.RequestContext, el *ElementNode, name string, src string, varName string) string {
+	tag := el.Raw
+
+	// Check & set connection
+	conn, err := utils.GetConnection(ctx, name)
+	if err != nil {
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("sql connection error. %v", err))
+	}
+
+	// Variables are bound as query arguments, a zin-data inside a loop can use the loop variables
+	scope := el.scope
+	if scope == nil {
+		scope = newScope(ctx)
+	}
+	params, _ := el.Attr("params")
+	query, args, err := bindSQL(src, splitParams(params), scope, dialectOf(conn))
+	if err != nil {
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), err.Error())
+	}
+
+	maxRows := utils.DefaultMaxRows
+	if raw, ok := el.Attr("max-rows"); ok {
+		if maxRows, err = strconv.Atoi(strings.TrimSpace(raw)); err != nil || maxRows < 1 {
+			return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Invalid 'max-rows' value '%s'. It must be a number above 0", raw))
+		}
+	}
+
+	// Run the query, cached results are keyed by the connection, SQL & bound values
+	key := fmt.Sprintf("sql://%s/%s %#v", name, query, args)
+	err = loadDataSource(ctx, el, key, varName, func(c *model.RequestContext) error {
+		return utils.RunQuery(c, conn, query, args, maxRows, varName)
+	})
 	if err != nil {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), err.Error())
+		return SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), err.Error())
+	}
+
+	// The rows up to max-rows are kept, the page can tell with {{ <as>_truncated }}
+	if ctx.CustomVar.Raw[varName+utils.TruncatedSuffix] == "true" {
+		return SetInlineError(ctx, fmt.Sprintf("Truncated: %s", tag), fmt.Sprintf("The query returned more than %d rows, only the first %d are shown. Add a LIMIT or raise max-rows", maxRows, maxRows))
 	}
 
 	return ""
@@ -0,0 +1,237 @@
+package directives
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"zin-engine/model"
+	"zin-engine/utils"
+)
+
+// Upper bound of remote data sources kept in memory
+const maxDataCacheEntries = 512
+
+// dataValue is what a zin-data source stored in its variable
+type dataValue struct {
+	json      map[string]any
+	list      []any
+	raw       string
+	kind      byte   // 'j', 'l' or 'r'
+	truncated string // <var>_truncated of a query cut at max-rows
+}
+
+type dataCacheEntry struct {
+	value      dataValue
+	fetched    time.Time
+	ttl        time.Duration
+	stale      time.Duration
+	refreshing bool
+}
+
+// DataCache keeps remote zin-data results by site & resolved source, shared by every request.
+// Cached values are read-only, directives never change loaded data in place.
+type DataCache struct {
+	mu      sync.Mutex
+	entries map[dataCacheKey]*dataCacheEntry
+}
+
+// dataCacheKey keeps sites apart, two sites loading the same URL never share a result
+type dataCacheKey struct {
+	root   string
+	source string
+}
+
+var dataCache = &DataCache{entries: make(map[dataCacheKey]*dataCacheEntry)}
+
+// fetchFunc loads a source into the variable of the given context
+type fetchFunc func(c *model.RequestContext) error
+
+// loadDataSource runs fetch, or serves it from the cache when the tag has cache="5m".
+// stale="1h" serves an expired value for that long while it is refreshed in the background,
+// and a failing fetch falls back to the last good value whatever its age.
+// source must hold everything the result depends on, the site root is added here.
+func loadDataSource(ctx *model.RequestContext, el *ElementNode, source string, varName string, fetch fetchFunc) error {
+	rawTTL, cached := el.Attr("cache")
+	if !cached {
+		return fetch(ctx)
+	}
+
+	ttl, err := time.ParseDuration(strings.TrimSpace(rawTTL))
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("invalid 'cache' value '%s', use a duration like 30s, 5m or 1h", rawTTL)
+	}
+	var stale time.Duration
+	if rawStale, ok := el.Attr("stale"); ok {
+		if stale, err = time.ParseDuration(strings.TrimSpace(rawStale)); err != nil || stale < 0 {
+			return fmt.Errorf("invalid 'stale' value '%s', use a duration like 30s, 5m or 1h", rawStale)
+		}
+	}
+
+	key := dataCacheKey{root: ctx.Root, source: source}
+	value, found, refresh := dataCache.lookup(key)
+	if found {
+		value.applyTo(ctx, varName)
+		if refresh {
+			// The refresh outlives the request, so it must not be cancelled with it
+			scratch := scratchContext(ctx)
+			scratch.Context = context.WithoutCancel(utils.RequestContext(ctx))
+			go dataCache.refresh(scratch, key, varName, fetch, ttl, stale)
+		}
+		return nil
+	}
+
+	scratch := scratchContext(ctx)
+	if err := fetch(scratch); err != nil {
+		// Serve stale on error
+		if value, ok := dataCache.last(key); ok {
+			fmt.Printf("[zin-data] serving stale %s: %v\n", source, err)
+			value.applyTo(ctx, varName)
+			return nil
+		}
+		return err
+	}
+
+	value, ok := captureData(scratch, varName)
+	if !ok {
+		return nil
+	}
+	dataCache.store(key, value, ttl, stale)
+	value.applyTo(ctx, varName)
+	return nil
+}
+
+// lookup returns a fresh value, or a stale one that may still be served and needs a background refresh
+func (dc *DataCache) lookup(key dataCacheKey) (dataValue, bool, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	entry, ok := dc.entries[key]
+	if !ok {
+		return dataValue{}, false, false
+	}
+
+	age := time.Since(entry.fetched)
+	if age < entry.ttl {
+		return entry.value, true, false
+	}
+	if age < entry.ttl+entry.stale {
+		refresh := !entry.refreshing
+		entry.refreshing = true
+		return entry.value, true, refresh
+	}
+	return dataValue{}, false, false
+}
+
+// last returns the cached value whatever its age
+func (dc *DataCache) last(key dataCacheKey) (dataValue, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	entry, ok := dc.entries[key]
+	if !ok {
+		return dataValue{}, false
+	}
+	return entry.value, true
+}
+
+func (dc *DataCache) store(key dataCacheKey, value dataValue, ttl time.Duration, stale time.Duration) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	// Make room by dropping the oldest entry
+	if _, exists := dc.entries[key]; !exists && len(dc.entries) >= maxDataCacheEntries {
+		var oldestKey dataCacheKey
+		var oldest time.Time
+		for k, entry := range dc.entries {
+			if oldest.IsZero() || entry.fetched.Before(oldest) {
+				oldestKey, oldest = k, entry.fetched
+			}
+		}
+		delete(dc.entries, oldestKey)
+	}
+
+	dc.entries[key] = &dataCacheEntry{value: value, fetched: time.Now(), ttl: ttl, stale: stale}
+}
+
+// refresh fetches a stale entry again outside the request, on failure the old value stays
+func (dc *DataCache) refresh(scratch *model.RequestContext, key dataCacheKey, varName string, fetch fetchFunc, ttl time.Duration, stale time.Duration) {
+	err := fetch(scratch)
+	value, ok := captureData(scratch, varName)
+
+	if err != nil || !ok {
+		fmt.Printf("[zin-data] background refresh of %s failed: %v\n", key.source, err)
+		dc.mu.Lock()
+		if entry, exists := dc.entries[key]; exists {
+			entry.refreshing = false
+		}
+		dc.mu.Unlock()
+		return
+	}
+	dc.store(key, value, ttl, stale)
+}
+
+// PurgeDataCache drops the cached sources of a site whose key contains match, all of them when match is empty
+func PurgeDataCache(root string, match string) int {
+	dataCache.mu.Lock()
+	defer dataCache.mu.Unlock()
+
+	purged := 0
+	for key := range dataCache.entries {
+		if key.root == root && strings.Contains(key.source, match) {
+			delete(dataCache.entries, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+// scratchContext is a copy of ctx for fetching, it has its own variables and no per-request maps
+func scratchContext(ctx *model.RequestContext) *model.RequestContext {
+	return &model.RequestContext{
+		Context:       ctx.Context,
+		ClientIp:      ctx.ClientIp,
+		Host:          ctx.Host,
+		Path:          ctx.Path,
+		Root:          ctx.Root,
+		ContentSource: ctx.ContentSource,
+		ServerVersion: ctx.ServerVersion,
+		ServerError:   make(map[string]string),
+		ENV:           ctx.ENV,
+		CustomVar: model.CustomVar{
+			Raw:  make(map[string]string),
+			JSON: make(map[string]map[string]any),
+			LIST: make(map[string][]any),
+		},
+		InlineErrors: ctx.InlineErrors,
+	}
+}
+
+// captureData reads what a fetch stored in its variable
+func captureData(c *model.RequestContext, varName string) (dataValue, bool) {
+	if v, ok := c.CustomVar.JSON[varName]; ok {
+		return dataValue{json: v, kind: 'j'}, true
+	}
+	if v, ok := c.CustomVar.LIST[varName]; ok {
+		return dataValue{list: v, kind: 'l', truncated: c.CustomVar.Raw[varName+utils.TruncatedSuffix]}, true
+	}
+	if v, ok := c.CustomVar.Raw[varName]; ok {
+		return dataValue{raw: v, kind: 'r'}, true
+	}
+	return dataValue{}, false
+}
+
+func (v dataValue) applyTo(ctx *model.RequestContext, varName string) {
+	switch v.kind {
+	case 'j':
+		ctx.CustomVar.JSON[varName] = v.json
+	case 'l':
+		ctx.CustomVar.LIST[varName] = v.list
+		if v.truncated != "" {
+			ctx.CustomVar.Raw[varName+utils.TruncatedSuffix] = v.truncated
+		}
+	case 'r':
+		ctx.CustomVar.Raw[varName] = v.raw
+	}
+}
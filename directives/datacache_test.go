@@ -0,0 +1,55 @@
+package directives
+
+import (
+	"testing"
+	"zin-engine/model"
+)
+
+func cachedDataTag(t *testing.T) *ElementNode {
+	t.Helper()
+	nodes := Parse(`<zin-data src="https://api.test/x" cache="5m" as="v" />`)
+	el, ok := nodes[0].(*ElementNode)
+	if !ok {
+		t.Fatalf("expected an element, got %#v", nodes[0])
+	}
+	return el
+}
+
+// Two sites loading the same source must never see each other's data, and purge only their own
+func TestDataCacheIsPerSite(t *testing.T) {
+	el := cachedDataTag(t)
+	siteA := testContext(t, t.TempDir(), "")
+	siteB := testContext(t, t.TempDir(), "")
+	source := "GET https://api.test/x||"
+
+	load := func(ctx *model.RequestContext, body string) string {
+		err := loadDataSource(ctx, el, source, "v", func(c *model.RequestContext) error {
+			c.CustomVar.Raw["v"] = body
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx.CustomVar.Raw["v"]
+	}
+
+	if got := load(siteA, "a"); got != "a" {
+		t.Fatalf("site A got %q", got)
+	}
+	if got := load(siteB, "b"); got != "b" {
+		t.Errorf("site B got %q, served the response of site A", got)
+	}
+	if got := load(siteA, "changed"); got != "a" {
+		t.Errorf("site A got %q, expected its cached value", got)
+	}
+
+	if n := PurgeDataCache(siteA.Root, "api.test"); n != 1 {
+		t.Errorf("purged %d entries of site A, want 1", n)
+	}
+	if got := load(siteB, "changed"); got != "b" {
+		t.Errorf("site B got %q after site A purged, expected its cached value", got)
+	}
+	if got := load(siteA, "fresh"); got != "fresh" {
+		t.Errorf("site A got %q after its purge", got)
+	}
+}
@@ -1,46 +1,118 @@
 package directives
 
 import (
+	"strings"
 	"zin-engine/model"
 	"zin-engine/utils"
 )
 
-type Directive func(string, *model.RequestContext) string
+// Phase transforms the whole parsed page once
+type Phase func([]Node, *model.RequestContext) []Node
 
-var (
-	currentFilePath  string
-	showInlineErrors string
-)
+// elementPhase runs a directive for every element with the given tag name
+func elementPhase(name string, directive Directive) Phase {
+	return func(nodes []Node, ctx *model.RequestContext) []Node {
+		return applyElementPhase(nodes, ctx, name, directive)
+	}
+}
+
+// Directives for zin elements, keyed by tag name
+var elementDirectives = map[string]Directive{
+	"zin-set":    setVarDirective,
+	"zin-time":   timeDirective,
+	"zin-random": randomDirective,
+	"zin-crypt":  cryptDirective,
+	"zin-data":   dataDirective,
+	"zin-repeat": loopDirective,
+	"zin-form":   formDirective,
+}
+
+// Directives that look at their siblings, e.g. zin-if and its else branches
+var chainDirectives = map[string]Phase{
+	"zin-if": ConditionalDirective,
+}
+
+// Fixed order used by the default "pipeline" evaluation, conditions come after loops so they see pager variables.
+// Directives inside a zin-if branch wait for the condition, those of an untaken branch never run.
+var pipelineOrder = []string{"zin-set", "zin-time", "zin-random", "zin-crypt", "zin-data", "zin-repeat", "zin-if", "zin-form"}
 
 func ParseAndApply(content string, ctx *model.RequestContext) string {
 
-	// Config
-	currentFilePath = ctx.ContentSource
-	showInlineErrors = utils.GetValue(ctx, "SHOW_ERRORS", "OFF", true)
+	// Sites opt into top to bottom evaluation with DIRECTIVE_ORDER=document in .env
+	ctx.DocumentOrder = strings.EqualFold(utils.GetEnvValue(ctx, "DIRECTIVE_ORDER", "pipeline"), "document")
+
+	// Tokenize once, every directive works on the same tree
+	nodes := Parse(content)
+
+	// Includes always come first, they only compose the page
+	nodes = IncludeDirective(nodes, ctx)
+
+	if ctx.DocumentOrder {
+		return Render(evaluateInOrder(nodes, ctx))
+	}
 
 	// List of directives to apply
-	directives := []Directive{
-		IncludeDirective,
-		SetVarDirectives,
-		TimeDirectives,
-		RandomDirective,
-		CryptDirective,
-		DataDirectives,
-		LoopDirectives,
-		FormDirective,
-		ReplaceVariables,
-		HighlightUnsupportedTags,
+	phases := make([]Phase, 0, len(pipelineOrder)+2)
+	for _, name := range pipelineOrder {
+		if phase, ok := chainDirectives[name]; ok {
+			phases = append(phases, phase)
+			continue
+		}
+		phases = append(phases, elementPhase(name, elementDirectives[name]))
 	}
+	phases = append(phases, ReplaceExpressionNodes, HighlightUnsupportedTags)
 
 	// Apply each directive in order, stop if errors found
-	for _, directive := range directives {
+	for _, phase := range phases {
 		if len(ctx.ServerError) > 0 {
 			break
 		}
-		content = directive(content, ctx)
+		nodes = phase(nodes, ctx)
 	}
 
-	return content
+	return Render(nodes)
+}
+
+// evaluateInOrder runs directives top to bottom, each one sees the variables defined before it
+func evaluateInOrder(nodes []Node, ctx *model.RequestContext) []Node {
+	out := make([]Node, 0, len(nodes))
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		if len(ctx.ServerError) > 0 {
+			out = append(out, node)
+			continue
+		}
+
+		switch n := node.(type) {
+		case *ExprNode:
+			out = append(out, expressionNode(ctx, n))
+		case *ElementNode:
+			// Only the chosen branch of a condition is evaluated
+			switch n.Name {
+			case "zin-if":
+				branch, next := chooseBranch(nodes, i, newScope(ctx))
+				out = append(out, evaluateInOrder(branch, ctx)...)
+				i = next - 1
+				continue
+			case "zin-elseif", "zin-else":
+				out = append(out, orphanBranchError(n, ctx)...)
+				continue
+			}
+
+			directive, ok := elementDirectives[n.Name]
+			if !ok {
+				n.mapStrings(func(s string) string { return n.replaceVariables(s, ctx) })
+				n.Children = evaluateInOrder(n.Children, ctx)
+				out = append(out, HighlightUnsupportedTags([]Node{n}, ctx)...)
+				continue
+			}
+			// Output of a directive (loop bodies, form fields) is evaluated in place
+			out = append(out, evaluateInOrder(directive(n, ctx), ctx)...)
+		default:
+			out = append(out, node)
+		}
+	}
+	return out
 }
 
 func SetServerError(ctx *model.RequestContext, title string, code string, reason string) {
@@ -49,9 +121,14 @@ func SetServerError(ctx *model.RequestContext, title string, code string, reason
 	ctx.ServerError["reason"] = reason
 }
 
-func SetInlineError(title string, content string) string {
-	if showInlineErrors != "ON" {
+func SetInlineError(ctx *model.RequestContext, title string, content string) string {
+	if !ctx.InlineErrors {
 		return ""
 	}
-	return utils.ComposeInlineErrorContent(title, content, currentFilePath)
+	return utils.ComposeInlineErrorContent(title, content, ctx.ContentSource)
+}
+
+// inlineError is SetInlineError as directive output
+func inlineError(ctx *model.RequestContext, title string, content string) []Node {
+	return textNodes(SetInlineError(ctx, title, content))
 }
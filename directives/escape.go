@@ -0,0 +1,398 @@
+package directives
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Values printed by {{ }} are escaped for the place they end up in, see escapeValue
+
+// contextKind is the kind of content a {{ }} is written into
+type contextKind uint8
+
+const (
+	contextText contextKind = iota // HTML text, also used between attributes of a tag
+	contextAttr                    // value of a plain attribute
+	contextURL                     // value of href, src, action, ...
+	contextJS                      // <script> body or an on* attribute
+	contextCSS                     // <style> body or a style attribute
+)
+
+// escapeContext is recorded on every ExprNode by the tokenizer
+type escapeContext struct {
+	kind     contextKind
+	attr     bool // inside an attribute value, attribute escaping applies last
+	quote    byte // quote of that attribute, 0 when unquoted
+	jsQuote  byte // quote of the JS string literal the value sits in, 0 outside strings
+	urlStart bool // nothing of the URL is written yet, so the value decides the scheme
+	urlQuery bool // the URL already has a ? or #
+}
+
+// safeValue is marked trusted with | raw or | safe and printed as it is
+type safeValue string
+
+// urlEncodedValue comes from | urlencode and is not encoded again inside URLs
+type urlEncodedValue string
+
+// jsonValue comes from | json, inside a script it already is a JS literal
+type jsonValue string
+
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true, "poster": true, "cite": true,
+	"background": true, "longdesc": true, "usemap": true, "data": true, "ping": true, "xlink:href": true,
+}
+
+// Schemes a value may start a URL attribute with, relative URLs are always fine
+var safeURLSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "tel": true}
+
+const unsafeURL = "#zin-unsafe-url"
+
+// escapeValue formats v for the context it is printed in
+func escapeValue(c escapeContext, v any) string {
+	if s, ok := v.(safeValue); ok {
+		return string(s)
+	}
+
+	var out string
+	switch c.kind {
+	case contextText:
+		if !c.attr {
+			return html.EscapeString(valueText(v))
+		}
+		out = valueText(v)
+	case contextAttr:
+		out = valueText(v)
+	case contextURL:
+		out = escapeURL(c, v)
+	case contextJS:
+		if c.jsQuote != 0 {
+			out = escapeJSString(valueText(v))
+		} else if literal, ok := v.(jsonValue); ok {
+			out = escapeScriptText(string(literal))
+		} else {
+			out = jsValue(v)
+		}
+	case contextCSS:
+		out = escapeCSS(valueText(v))
+	}
+
+	if c.attr || c.kind == contextText {
+		return escapeAttr(out, c.quote)
+	}
+	return out
+}
+
+// valueText is what {{ }} prints for v, missing values print undefined
+func valueText(v any) string {
+	if v == nil {
+		return "undefined"
+	}
+	return toText(v)
+}
+
+// escapeAttr escapes for a quoted attribute, unquoted ones also lose spaces & delimiters
+func escapeAttr(s string, quote byte) string {
+	if quote != 0 {
+		return html.EscapeString(s)
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf && (isWordChar(byte(r)) || strings.ContainsRune("-.:/", r)) || r >= utf8.RuneSelf && unicode.IsLetter(r) {
+			b.WriteRune(r)
+			continue
+		}
+		fmt.Fprintf(&b, "&#x%x;", r)
+	}
+	return b.String()
+}
+
+// escapeURL refuses script URLs at the start of an attribute and percent-encodes the rest.
+// After ? or # a value is a query component, so & = + are encoded too.
+func escapeURL(c escapeContext, v any) string {
+	if encoded, ok := v.(urlEncodedValue); ok {
+		return string(encoded)
+	}
+
+	s := valueText(v)
+	if c.urlStart {
+		if i := strings.IndexAny(s, ":/?#"); i > 0 && s[i] == ':' && !safeURLSchemes[strings.ToLower(s[:i])] {
+			return unsafeURL
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case isWordChar(ch) || strings.IndexByte("-.~", ch) >= 0:
+			b.WriteByte(ch)
+		case ch == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(ch)
+		case !c.urlQuery && strings.IndexByte(":/?#[]@!$&'()*+,;=", ch) >= 0:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// jsValue writes v as a JS literal, strings are quoted and <, >, & never appear raw
+func jsValue(v any) string {
+	switch val := v.(type) {
+	case safeValue:
+		v = string(val)
+	case urlEncodedValue:
+		v = string(val)
+	case jsonValue:
+		v = string(val)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		out, _ = json.Marshal(toText(v))
+	}
+	return string(out)
+}
+
+// escapeScriptText keeps a JS literal from closing the script or opening an HTML comment
+func escapeScriptText(s string) string {
+	s = strings.ReplaceAll(s, "</", `<\/`)
+	return strings.ReplaceAll(s, "<!--", `<\!--`)
+}
+
+// escapeJSString escapes for the inside of a quoted or template JS string literal
+func escapeJSString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf && (isWordChar(byte(r)) || r == ' ' || strings.ContainsRune(".,:;-_!?@#()[]{}*+/|^~", r)):
+			b.WriteRune(r)
+		case r >= utf8.RuneSelf && r != '\u2028' && r != '\u2029':
+			b.WriteRune(r)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+	}
+	return b.String()
+}
+
+// escapeCSS keeps letters and digits, everything else becomes a CSS hex escape
+func escapeCSS(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf && (isWordChar(byte(r)) || r == '-' || r == ' ' || r == '.' || r == '#' || r == '%') || r >= utf8.RuneSelf && unicode.IsLetter(r) {
+			b.WriteRune(r)
+			continue
+		}
+		fmt.Fprintf(&b, `\%x `, r)
+	}
+	return b.String()
+}
+
+// htmlState follows the markup around zin tags and expressions to know their escape context
+type htmlState struct {
+	state     uint8
+	tag       string // lower case name of the tag being read, or the raw text element we are in
+	closing   bool
+	attr      string // lower case name of the attribute being read
+	quote     byte
+	value     strings.Builder
+	jsQuote   byte
+	jsComment byte   // '/' in a line comment, '*' in a block comment
+	rawText   string // script or style while inside their body
+}
+
+const (
+	stateText uint8 = iota
+	stateComment
+	stateTagName
+	stateInTag
+	stateAttrName
+	stateBeforeValue
+	stateValue
+	stateRawText
+)
+
+// feed advances the state over a piece of markup
+func (h *htmlState) feed(s string) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch h.state {
+		case stateText:
+			if strings.HasPrefix(s[i:], "<!--") {
+				h.state = stateComment
+				i += 3
+			} else if c == '<' && i+1 < len(s) && (isLetter(s[i+1]) || s[i+1] == '/') {
+				h.state, h.tag, h.closing = stateTagName, "", s[i+1] == '/'
+				if h.closing {
+					i++
+				}
+			}
+		case stateComment:
+			if strings.HasPrefix(s[i:], "-->") {
+				h.state = stateText
+				i += 2
+			}
+		case stateTagName:
+			if isSpace(c) || c == '>' || c == '/' {
+				h.state = stateInTag
+				i--
+				continue
+			}
+			h.tag += string(unicode.ToLower(rune(c)))
+		case stateInTag:
+			switch {
+			case c == '>':
+				h.endTag()
+			case c == '=':
+				h.state = stateBeforeValue
+			case !isSpace(c) && c != '/':
+				h.state, h.attr = stateAttrName, string(unicode.ToLower(rune(c)))
+			}
+		case stateAttrName:
+			switch {
+			case c == '=':
+				h.state = stateBeforeValue
+			case c == '>':
+				h.endTag()
+			case isSpace(c) || c == '/':
+				h.state = stateInTag
+			default:
+				h.attr += string(unicode.ToLower(rune(c)))
+			}
+		case stateBeforeValue:
+			switch {
+			case isSpace(c):
+			case c == '>':
+				h.endTag()
+			default:
+				h.state, h.quote, h.jsQuote = stateValue, 0, 0
+				h.value.Reset()
+				if c == '"' || c == '\'' {
+					h.quote = c
+				} else {
+					i--
+				}
+			}
+		case stateValue:
+			if h.quote != 0 && c == h.quote || h.quote == 0 && isSpace(c) {
+				h.state = stateInTag
+				continue
+			}
+			if h.quote == 0 && c == '>' {
+				h.endTag()
+				continue
+			}
+			h.value.WriteByte(c)
+			if strings.HasPrefix(h.attr, "on") {
+				h.trackJSQuote(c)
+			}
+		case stateRawText:
+			if c == '<' && strings.HasPrefix(strings.ToLower(s[i:]), "</"+h.rawText) {
+				h.state, h.tag, h.closing, h.rawText = stateTagName, "", true, ""
+				i++
+				continue
+			}
+			if h.rawText != "script" {
+				continue
+			}
+			switch {
+			case h.jsComment == '/':
+				if c == '\n' {
+					h.jsComment = 0
+				}
+			case h.jsComment == '*':
+				if strings.HasPrefix(s[i:], "*/") {
+					h.jsComment = 0
+					i++
+				}
+			case h.jsQuote == 0 && strings.HasPrefix(s[i:], "//"):
+				h.jsComment = '/'
+			case h.jsQuote == 0 && strings.HasPrefix(s[i:], "/*"):
+				h.jsComment = '*'
+			default:
+				h.trackJSQuote(c)
+			}
+		}
+	}
+}
+
+func (h *htmlState) endTag() {
+	h.state = stateText
+	if !h.closing && (h.tag == "script" || h.tag == "style") {
+		h.state, h.rawText, h.jsQuote, h.jsComment = stateRawText, h.tag, 0, 0
+	}
+}
+
+// written notes that an expression printed something at the current position
+func (h *htmlState) written() {
+	switch h.state {
+	case stateBeforeValue:
+		h.state, h.quote, h.jsQuote = stateValue, 0, 0
+		h.value.Reset()
+		h.value.WriteString("{{}}")
+	case stateValue:
+		h.value.WriteString("{{}}")
+	}
+}
+
+// trackJSQuote notes when a JS string literal opens or closes, \ escapes are honoured
+func (h *htmlState) trackJSQuote(c byte) {
+	switch {
+	case h.jsQuote == 0 && (c == '"' || c == '\'' || c == '`'):
+		h.jsQuote = c
+	case h.jsQuote != 0 && c == '\\':
+		h.jsQuote |= 0x80 // the next character is escaped
+	case h.jsQuote&0x80 != 0:
+		h.jsQuote &^= 0x80
+	case c == h.jsQuote:
+		h.jsQuote = 0
+	}
+}
+
+// context is the escape context for something written at the current position
+func (h *htmlState) context() escapeContext {
+	switch h.state {
+	case stateValue, stateBeforeValue:
+		c := escapeContext{attr: true, quote: h.quote, kind: contextAttr}
+		if h.state == stateBeforeValue {
+			c.quote = 0
+		}
+		switch {
+		case strings.HasPrefix(h.attr, "on"):
+			c.kind, c.jsQuote = contextJS, h.jsQuote&^0x80
+		case h.attr == "style":
+			c.kind = contextCSS
+		case urlAttributes[h.attr]:
+			value := h.value.String()
+			if h.state == stateBeforeValue {
+				value = ""
+			}
+			c.kind = contextURL
+			c.urlStart = strings.TrimSpace(value) == ""
+			c.urlQuery = strings.ContainsAny(value, "?#")
+		}
+		return c
+	case stateRawText:
+		if h.rawText == "script" {
+			return escapeContext{kind: contextJS, jsQuote: h.jsQuote &^ 0x80}
+		}
+		return escapeContext{kind: contextCSS}
+	case stateTagName, stateInTag, stateAttrName:
+		return escapeContext{kind: contextText, attr: true}
+	}
+	return escapeContext{kind: contextText}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
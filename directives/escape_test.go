@@ -0,0 +1,67 @@
+package directives
+
+import "testing"
+
+func TestExpressionEscaping(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Text & attributes
+		{`<p>{{ v }}</p>`, `<p>&lt;/script&gt;&lt;b x=&#39;1&#39;&gt;&#34;&amp;</p>`},
+		{`<a title="{{ v }}">`, `<a title="&lt;/script&gt;&lt;b x=&#39;1&#39;&gt;&#34;&amp;">`},
+		{`<a title='{{ v }}'>`, `<a title='&lt;/script&gt;&lt;b x=&#39;1&#39;&gt;&#34;&amp;'>`},
+		{`<a title={{ v }}>`, `<a title=&#x3c;/script&#x3e;&#x3c;b&#x20;x&#x3d;&#x27;1&#x27;&#x3e;&#x22;&#x26;>`},
+		{`<p>{{ missing }}</p>`, `<p>undefined</p>`},
+		{`<p>{{ v | raw }}</p>`, `<p></script><b x='1'>"&</p>`},
+
+		// URLs
+		{`<a href="{{ v }}">`, `<a href="%3C/script%3E%3Cb%20x=&#39;1&#39;%3E%22&amp;">`},
+		{`<a href="{{ js }}">`, `<a href="#zin-unsafe-url">`},
+		{`<a href='{{ data }}'>`, `<a href='#zin-unsafe-url'>`},
+		{`<a href="{{ web }}">`, `<a href="https://example.test/a%20b?x=1&amp;y=2">`},
+		{`<a href="/go/{{ js }}">`, `<a href="/go/JavaScript:alert(1)">`}, // only the start of a URL names a scheme
+		{`<a href="/s?q={{ v }}">`, `<a href="/s?q=%3C%2Fscript%3E%3Cb%20x%3D%271%27%3E%22%26">`},
+		{`<a href="/s?q={{ web | urlencode }}">`, `<a href="/s?q=https%3A%2F%2Fexample.test%2Fa+b%3Fx%3D1%26y%3D2">`},
+
+		// Scripts
+		{`<script>var a = {{ v }};</script>`, `<script>var a = "\u003c/script\u003e\u003cb x='1'\u003e\"\u0026";</script>`},
+		{`<script>var a = "{{ v }}";</script>`, `<script>var a = "\u003c/script\u003e\u003cb x\u003d\u00271\u0027\u003e\u0022\u0026";</script>`},
+		{`<script>var a = '{{ n }}';</script>`, `<script>var a = '42';</script>`},
+		{`<script>var a = {{ items }};</script>`, `<script>var a = [1,3,2];</script>`},
+		{`<script>var a = {{ items | json }};</script>`, `<script>var a = [1,3,2];</script>`},
+		{`<script>var a = {{ v | json }};</script>`, `<script>var a = "\u003c/script\u003e\u003cb x='1'\u003e\"\u0026";</script>`},
+		{`<script>var a = "{{ items | json }}";</script>`, `<script>var a = "[1,3,2]";</script>`},
+		{`<p>{{ items | json }}</p>`, `<p>[1,3,2]</p>`},
+		{`<button onclick="go({{ v }})">`, `<button onclick="go(&#34;\u003c/script\u003e\u003cb x=&#39;1&#39;\u003e\&#34;\u0026&#34;)">`},
+
+		// Styles
+		{`<style>p { color: {{ v }} }</style>`, `<style>p { color: \3c \2f script\3e \3c b x\3d \27 1\27 \3e \22 \26  }</style>`},
+		{`<p style="color: {{ v }}">`, `<p style="color: \3c \2f script\3e \3c b x\3d \27 1\27 \3e \22 \26 ">`},
+		{`<p style="color: {{ color }}">`, `<p style="color: #336699">`},
+	}
+
+	for _, tt := range tests {
+		ctx := testContext(t, t.TempDir(), "")
+		ctx.CustomVar.Raw["v"] = `</script><b x='1'>"&`
+		ctx.CustomVar.Raw["js"] = `JavaScript:alert(1)`
+		ctx.CustomVar.Raw["data"] = `data:text/html,<script>alert(1)</script>`
+		ctx.CustomVar.Raw["web"] = `https://example.test/a b?x=1&y=2`
+		ctx.CustomVar.Raw["n"] = `42`
+		ctx.CustomVar.Raw["color"] = `#336699`
+		ctx.CustomVar.LIST["items"] = []any{1.0, 3.0, 2.0}
+
+		if got := ParseAndApply(tt.src, ctx); got != tt.want {
+			t.Errorf("%s\n got %s\nwant %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+// Text of the json filter is printed as is in a script, it still can't end the script or open a comment
+func TestJSONLiteralInScript(t *testing.T) {
+	got := escapeValue(escapeContext{kind: contextJS}, jsonValue(`{"a":"</script>","b":"<!--"}`))
+	want := `{"a":"<\/script>","b":"<\!--"}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,664 @@
+package directives
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"zin-engine/utils"
+)
+
+// Expressions used inside {{ }} and by zin-if conditions
+// e.g. user.role == "admin" && !empty(posts), price * qty | round:2, title | truncate:80
+
+type exprNode interface {
+	eval(s *Scope) (any, error)
+}
+
+type literalExpr struct{ value any }
+
+type pathExpr struct{ path string }
+
+type unaryExpr struct {
+	op string
+	x  exprNode
+}
+
+type binaryExpr struct {
+	op          string
+	left, right exprNode
+}
+
+type filterExpr struct {
+	name string
+	x    exprNode
+	args []exprNode
+}
+
+func (e *literalExpr) eval(s *Scope) (any, error) { return e.value, nil }
+
+func (e *pathExpr) eval(s *Scope) (any, error) {
+	val, _ := s.Lookup(e.path)
+	return val, nil
+}
+
+func (e *unaryExpr) eval(s *Scope) (any, error) {
+	if p, ok := e.x.(*pathExpr); ok && e.op == "exists" {
+		_, found := s.Lookup(p.path)
+		return found, nil
+	}
+
+	v, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "!":
+		return !truthy(v), nil
+	case "empty":
+		return isEmpty(v), nil
+	case "exists":
+		return v != nil, nil
+	case "-":
+		n, ok := toNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("can't negate '%s', it is not a number", toText(v))
+		}
+		return -n, nil
+	}
+	return nil, nil
+}
+
+func (e *binaryExpr) eval(s *Scope) (any, error) {
+	a, err := e.left.eval(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// a || "default" gives the first value that is set, && short-circuits
+	switch e.op {
+	case "||":
+		if truthy(a) {
+			return a, nil
+		}
+		return e.right.eval(s)
+	case "&&":
+		if !truthy(a) {
+			return false, nil
+		}
+		b, err := e.right.eval(s)
+		return truthy(b), err
+	}
+
+	b, err := e.right.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return compareValues(a, b) == 0, nil
+	case "!=":
+		return compareValues(a, b) != 0, nil
+	case "<":
+		return compareValues(a, b) < 0, nil
+	case "<=":
+		return compareValues(a, b) <= 0, nil
+	case ">":
+		return compareValues(a, b) > 0, nil
+	case ">=":
+		return compareValues(a, b) >= 0, nil
+	case "~":
+		return toText(a) + toText(b), nil
+	}
+	return arithmetic(e.op, a, b)
+}
+
+func (e *filterExpr) eval(s *Scope) (any, error) {
+	v, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		if args[i], err = arg.eval(s); err != nil {
+			return nil, err
+		}
+	}
+	return exprFilters[e.name](v, args)
+}
+
+// arithmetic applies + - * / %, + joins text when either side is not a number
+func arithmetic(op string, a, b any) (any, error) {
+	x, okA := toNumber(a)
+	y, okB := toNumber(b)
+	if !okA || !okB {
+		if op == "+" {
+			return toText(a) + toText(b), nil
+		}
+		return nil, fmt.Errorf("'%s' needs numbers, got '%s' and '%s'", op, toText(a), toText(b))
+	}
+
+	var n float64
+	switch op {
+	case "+":
+		n = x + y
+	case "-":
+		n = x - y
+	case "*":
+		n = x * y
+	case "/", "%":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == "%" {
+			n = math.Mod(x, y)
+		} else {
+			n = x / y
+		}
+	default:
+		return nil, fmt.Errorf("unknown operator '%s'", op)
+	}
+
+	if !isFinite(n) {
+		return nil, fmt.Errorf("'%s' of '%s' and '%s' is out of range", op, toText(a), toText(b))
+	}
+	return n, nil
+}
+
+// evalExpression parses and evaluates src
+func evalExpression(src string, s *Scope) (any, error) {
+	expr, err := parseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	return expr.eval(s)
+}
+
+// exprRoots lists the variable names an expression reads, e.g. user for user.name
+func exprRoots(n exprNode) []string {
+	switch e := n.(type) {
+	case *pathExpr:
+		return utils.SplitKeyPath(e.path)[:1]
+	case *unaryExpr:
+		return exprRoots(e.x)
+	case *binaryExpr:
+		return append(exprRoots(e.left), exprRoots(e.right)...)
+	case *filterExpr:
+		roots := exprRoots(e.x)
+		for _, arg := range e.args {
+			roots = append(roots, exprRoots(arg)...)
+		}
+		return roots
+	}
+	return nil
+}
+
+// Token kinds of the expression lexer
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+var exprOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "|", ":", ",", "+", "-", "*", "/", "%", "~"}
+
+// lexExpr splits an expression into tokens, paths like users[0].first-name are one token
+func lexExpr(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at column %d", i+1)
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start})
+		case isWordChar(c):
+			start := i
+			for i < len(src) && isPathChar(src, i) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range exprOperators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected '%c' at column %d", c, i+1)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// isPathChar allows a hyphen only between word characters, e.g. sub-key
+func isPathChar(src string, i int) bool {
+	c := src[i]
+	if isWordChar(c) || c == '.' || c == '[' || c == ']' {
+		return true
+	}
+	return c == '-' && i > 0 && isWordChar(src[i-1]) && i+1 < len(src) && isWordChar(src[i+1])
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+// parseExpr parses a whole expression, trailing tokens are an error
+func parseExpr(src string) (exprNode, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at column %d", t.text, t.pos+1)
+	}
+	return node, nil
+}
+
+// parseAttrExpr parses an expression attribute such as cond or where, e.g. `price < {{ max }}` or
+// `title == 'Re: {{ q }}'`. Each {{ }} is evaluated once with scope and comes in as a variable of
+// the returned scope, its value is never read as expression syntax.
+func parseAttrExpr(src string, scope *Scope) (exprNode, *Scope, error) {
+	if !strings.Contains(src, "{{") {
+		node, err := parseExpr(src)
+		return node, scope, err
+	}
+
+	vars := make(map[string]any)
+	bind := func(inner string) string {
+		name := fmt.Sprintf("zin_attr_%d", len(vars))
+		val, err := resolveValue(scope, strings.TrimSpace(inner))
+		if err != nil {
+			val = nil
+		}
+		vars[name] = val
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			// 'a {{ x }} b' becomes ('a ' ~ zin_attr_0 ~ ' b')
+			b.WriteString("(")
+			b.WriteByte(c)
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if strings.HasPrefix(src[j:], "{{") {
+					if inner, end, ok := matchExpression(src, j); ok {
+						fmt.Fprintf(&b, "%c ~ %s ~ %c", c, bind(inner), c)
+						j = end - 1
+						continue
+					}
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, nil, fmt.Errorf("unterminated string at column %d", i+1)
+			}
+			b.WriteByte(c)
+			b.WriteString(")")
+			i = j
+		case strings.HasPrefix(src[i:], "{{"):
+			inner, end, ok := matchExpression(src, i)
+			if !ok {
+				b.WriteByte(c)
+				continue
+			}
+			fmt.Fprintf(&b, " %s ", bind(inner))
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	node, err := parseExpr(b.String())
+	return node, scope.child(vars), err
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators or keywords
+func (p *exprParser) accept(words ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return "", false
+	}
+	for _, w := range words {
+		if t.text == w {
+			p.pos++
+			return w, true
+		}
+	}
+	return "", false
+}
+
+// parsePipe handles value | filter:arg, arg | filter, a pipe applies to everything on its left
+func (p *exprParser) parsePipe() (exprNode, error) {
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("|"); !ok {
+			return x, nil
+		}
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, fmt.Errorf("missing filter name at column %d", name.pos+1)
+		}
+		if _, known := exprFilters[name.text]; !known {
+			return nil, fmt.Errorf("unknown filter '%s'", name.text)
+		}
+
+		f := &filterExpr{name: name.text, x: x}
+		if _, ok := p.accept(":"); ok {
+			for {
+				arg, err := p.parseUnary()
+				if err != nil {
+					return nil, err
+				}
+				f.args = append(f.args, arg)
+				if _, ok := p.accept(","); !ok {
+					break
+				}
+			}
+		}
+		x = f
+	}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "||", left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "&&", left: left, right: right}
+	}
+}
+
+// parseNot makes `not a == b` and `!a == b` both mean not (a == b)
+func (p *exprParser) parseNot() (exprNode, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "!", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", "<=", ">=", "<", ">"); ok {
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryExpr{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+// parseAdditive handles + - and ~, which always joins as text
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-", "~")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+// parseUnary handles -x, `empty x` and `exists x`, with or without parentheses
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("-", "empty", "exists"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return &literalExpr{value: t.text}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at column %d", t.text, t.pos+1)
+		}
+		return &literalExpr{value: n}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalExpr{value: true}, nil
+		case "false":
+			return &literalExpr{value: false}, nil
+		case "null", "nil":
+			return &literalExpr{value: nil}, nil
+		}
+		return &pathExpr{path: t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("missing ')' at column %d", p.peek().pos+1)
+			}
+			return x, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected '%s' at column %d", t.text, t.pos+1)
+}
+
+// truthy: missing, false, "", "0", "false", 0 and empty lists or objects are false
+func truthy(v any) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != "" && val != "0" && val != "false"
+	case []any:
+		return len(val) > 0
+	case map[string]any:
+		return len(val) > 0
+	}
+	if n, ok := toNumber(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+// isEmpty is true for missing values, "", and lists or objects without entries
+func isEmpty(v any) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(val) == ""
+	case []any:
+		return len(val) == 0
+	case map[string]any:
+		return len(val) == 0
+	}
+	return false
+}
+
+// toNumber converts numbers and numeric strings, query params and .env values are always strings
+func toNumber(v any) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case string:
+		// NaN, Inf and out of range text like 1e999 are no numbers, they stay text
+		n, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return n, err == nil && isFinite(n)
+	}
+	return 0, false
+}
+
+func isFinite(n float64) bool {
+	return !math.IsNaN(n) && !math.IsInf(n, 0)
+}
+
+// toText formats a value the way {{ }} prints it, missing values are ""
+func toText(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case safeValue:
+		return string(val)
+	case urlEncodedValue:
+		return string(val)
+	case jsonValue:
+		return string(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		return val.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// compareValues compares as numbers when both sides are numeric, otherwise as text
+func compareValues(a, b any) int {
+	if _, isBool := a.(bool); isBool {
+		return strings.Compare(toText(a), toText(truthy(b)))
+	}
+	if _, isBool := b.(bool); isBool {
+		return strings.Compare(toText(truthy(a)), toText(b))
+	}
+	x, okA := toNumber(a)
+	y, okB := toNumber(b)
+	if okA && okB {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(toText(a), toText(b))
+}
@@ -0,0 +1,105 @@
+package directives
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func exprTestScope(t *testing.T) *Scope {
+	ctx := testContext(t, t.TempDir(), "q=10&name=query")
+	return newScope(ctx).child(map[string]any{
+		"user":  map[string]any{"name": "Ann", "role": "admin"},
+		"posts": []any{map[string]any{"title": "a"}, map[string]any{"title": "b"}},
+		"n":     3.0,
+		"s":     "10",
+		"none":  []any{},
+	})
+}
+
+func TestEvalExpression(t *testing.T) {
+	tests := []struct {
+		src  string
+		want any
+	}{
+		{`1 + 2 * 3`, 7.0},
+		{`(1 + 2) * 3`, 9.0},
+		{`10 % 4`, 2.0},
+		{`10 / 4`, 2.5},
+		{`-n`, -3.0},
+		{`"a" ~ 1`, "a1"},
+		{`"a" + "b"`, "ab"},
+		{`user.name`, "Ann"},
+		{`posts[1].title`, "b"},
+		{`posts[5].title`, nil},
+		{`user.role == "admin" && !empty(posts)`, true},
+		{`user.role == "admin" and empty none`, true},
+		{`not user.role == "admin"`, false},
+		{`!user.role == "admin"`, false},
+		{`missing || "x"`, "x"},
+		{`user.name || "x"`, "Ann"},
+		{`missing && true`, false},
+		{`exists missing`, false},
+		{`exists user.name`, true},
+		{`s > 9`, true},
+		{`"10" == 10`, true},
+		{`"b" > "a"`, true},
+		{`q >= 10`, true},
+		{`name`, "query"},
+		{`true == "1"`, true},
+		{`null`, nil},
+		{`user.name | upper | truncate:2`, "AN..."},
+		{`(user.name ~ "!") | lower`, "ann!"},
+	}
+
+	for _, tt := range tests {
+		got, err := evalExpression(tt.src, exprTestScope(t))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalExpressionErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`1 +`, "unexpected end of expression"},
+		{`(1 + 2`, "missing ')'"},
+		{`"abc`, "unterminated string"},
+		{`a | nope`, "unknown filter 'nope'"},
+		{`a |`, "missing filter name"},
+		{`a b`, "unexpected 'b'"},
+		{`a @ b`, "unexpected '@'"},
+		{`1.2.3`, "invalid number"},
+		{`1 / 0`, "division by zero"},
+		{`"1e308" * 10`, "out of range"},
+		{`"-1e308" - "1e308"`, "out of range"},
+		{`"a" * 2`, "needs numbers"},
+		{`-user.name`, "can't negate"},
+	}
+
+	for _, tt := range tests {
+		_, err := evalExpression(tt.src, exprTestScope(t))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestExprRoots(t *testing.T) {
+	expr, err := parseExpr(`user.name ~ posts[0].title | default:fallback`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := exprRoots(expr)
+	want := []string{"user", "posts", "fallback"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("roots = %v, want %v", got, want)
+	}
+}
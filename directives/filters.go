@@ -0,0 +1,175 @@
+package directives
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// filterFunc transforms the value on the left of a pipe, args come after the colon
+type filterFunc func(v any, args []any) (any, error)
+
+// Filters usable in {{ value | name:arg }}
+var exprFilters = map[string]filterFunc{
+	"upper":      func(v any, args []any) (any, error) { return strings.ToUpper(toText(v)), nil },
+	"lower":      func(v any, args []any) (any, error) { return strings.ToLower(toText(v)), nil },
+	"trim":       func(v any, args []any) (any, error) { return strings.TrimSpace(toText(v)), nil },
+	"capitalize": capitalizeFilter,
+	"truncate":   truncateFilter,
+	"date":       dateFilter,
+	"json":       jsonFilter,
+	"urlencode":  func(v any, args []any) (any, error) { return urlEncodedValue(url.QueryEscape(toText(v))), nil },
+	"default":    defaultFilter,
+	"length":     lengthFilter,
+	"join":       joinFilter,
+	"round":      roundFilter,
+	"raw":        rawFilter,
+	"safe":       rawFilter,
+}
+
+const defaultDateLayout = "Jan 2, 2006"
+
+// More decimals than a float64 holds only prints noise
+const maxRoundPlaces = 17
+
+// Layouts tried, in order, when a date is given as text
+var dateInputLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", time.RFC1123Z, time.RFC1123}
+
+func capitalizeFilter(v any, args []any) (any, error) {
+	s := toText(v)
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s, nil
+	}
+	return string(unicode.ToUpper(r)) + s[size:], nil
+}
+
+// truncate:80 or truncate:80,"…" cuts at a rune boundary and appends the suffix
+func truncateFilter(v any, args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("truncate needs a length e.g. truncate:80")
+	}
+	n, ok := toNumber(args[0])
+	if !ok || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil, fmt.Errorf("truncate length '%s' is not a positive number", toText(args[0]))
+	}
+	suffix := "..."
+	if len(args) > 1 {
+		suffix = toText(args[1])
+	}
+
+	// Clamp before converting, a huge float doesn't fit an int
+	runes := []rune(toText(v))
+	if n >= float64(len(runes)) {
+		return string(runes), nil
+	}
+	return string(runes[:int(n)]) + suffix, nil
+}
+
+// date:"Jan 2" formats a time with a Go layout, input can be text, a unix timestamp or "now"
+func dateFilter(v any, args []any) (any, error) {
+	layout := defaultDateLayout
+	if len(args) > 0 {
+		layout = toText(args[0])
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.Format(layout), nil
+	}
+	if n, ok := toNumber(v); ok {
+		return time.Unix(int64(n), 0).Format(layout), nil
+	}
+
+	s := strings.TrimSpace(toText(v))
+	if strings.EqualFold(s, "now") {
+		return time.Now().Format(layout), nil
+	}
+	for _, in := range dateInputLayouts {
+		if t, err := time.Parse(in, s); err == nil {
+			return t.Format(layout), nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not a date", s)
+}
+
+func jsonFilter(v any, args []any) (any, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("can't convert to json: %v", err)
+	}
+	return jsonValue(out), nil
+}
+
+// default:"x" replaces missing and empty values
+func defaultFilter(v any, args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf(`default needs a value e.g. default:"x"`)
+	}
+	if isEmpty(v) {
+		return args[0], nil
+	}
+	return v, nil
+}
+
+func lengthFilter(v any, args []any) (any, error) {
+	switch val := v.(type) {
+	case nil:
+		return 0, nil
+	case []any:
+		return len(val), nil
+	case map[string]any:
+		return len(val), nil
+	}
+	return utf8.RuneCountInString(toText(v)), nil
+}
+
+// join:", " glues the items of a list
+func joinFilter(v any, args []any) (any, error) {
+	sep := ", "
+	if len(args) > 0 {
+		sep = toText(args[0])
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return toText(v), nil
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = toText(item)
+	}
+	return strings.Join(items, sep), nil
+}
+
+// round:2 rounds to a number of decimals, always printing them
+func roundFilter(v any, args []any) (any, error) {
+	n, ok := toNumber(v)
+	if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil, fmt.Errorf("can't round '%s', it is not a number", toText(v))
+	}
+	places := 0.0
+	if len(args) > 0 {
+		if places, ok = toNumber(args[0]); !ok || places < 0 || places > maxRoundPlaces || math.IsNaN(places) {
+			return nil, fmt.Errorf("round places '%s' is not a number from 0 to %d", toText(args[0]), maxRoundPlaces)
+		}
+	}
+	// Numbers too large to scale have no decimals left to round
+	pow := math.Pow(10, places)
+	if scaled := n * pow; isFinite(scaled) {
+		n = math.Round(scaled) / pow
+	}
+	return strconv.FormatFloat(n, 'f', int(places), 64), nil
+}
+
+// raw & safe mark a trusted value, it is printed without escaping. Use it as the last filter.
+func rawFilter(v any, args []any) (any, error) {
+	return safeValue(valueText(v)), nil
+}
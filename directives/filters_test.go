@@ -0,0 +1,137 @@
+package directives
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilters(t *testing.T) {
+	day := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		filter string
+		in     any
+		args   []any
+		want   any
+	}{
+		{"upper", "abc", nil, "ABC"},
+		{"lower", "AbC", nil, "abc"},
+		{"trim", "  a b  ", nil, "a b"},
+		{"capitalize", "élan", nil, "Élan"},
+		{"capitalize", "", nil, ""},
+		{"truncate", "hello", []any{3.0}, "hel..."},
+		{"truncate", "héllo", []any{2.0, "…"}, "hé…"},
+		{"truncate", "hello", []any{5.0}, "hello"},
+		{"truncate", "hello", []any{0.0}, "..."},
+		{"truncate", "hello", []any{"2"}, "he..."},
+		{"truncate", "hello", []any{1e20}, "hello"},
+		{"truncate", "hello", []any{"1e20"}, "hello"},
+		{"date", day, []any{"2006-01-02"}, "2024-03-05"},
+		{"date", "2024-03-05", []any{"Jan 2"}, "Mar 5"},
+		{"date", "2024-03-05T14:30:00Z", nil, "Mar 5, 2024"},
+		{"date", nil, nil, nil},
+		{"json", map[string]any{"a": 1.0}, nil, jsonValue(`{"a":1}`)},
+		{"json", []any{"x", 2.0}, nil, jsonValue(`["x",2]`)},
+		{"urlencode", "a b&c", nil, urlEncodedValue("a+b%26c")},
+		{"default", "", []any{"x"}, "x"},
+		{"default", nil, []any{"x"}, "x"},
+		{"default", "y", []any{"x"}, "y"},
+		{"length", []any{1, 2}, nil, 2},
+		{"length", map[string]any{"a": 1}, nil, 1},
+		{"length", "héllo", nil, 5},
+		{"length", nil, nil, 0},
+		{"join", []any{"a", 1.0}, nil, "a, 1"},
+		{"join", []any{"a", "b"}, []any{"-"}, "a-b"},
+		{"join", "solo", nil, "solo"},
+		{"round", 3.14159, []any{2.0}, "3.14"},
+		{"round", "2.5", nil, "3"},
+		{"round", 1.0, []any{2.0}, "1.00"},
+		{"raw", "<b>", nil, safeValue("<b>")},
+		{"safe", nil, nil, safeValue("undefined")},
+	}
+
+	for _, tt := range tests {
+		got, err := exprFilters[tt.filter](tt.in, tt.args)
+		if err != nil {
+			t.Errorf("%s(%v, %v): unexpected error %v", tt.filter, tt.in, tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s(%v, %v) = %#v, want %#v", tt.filter, tt.in, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFilterBadArguments(t *testing.T) {
+	tests := []struct {
+		filter string
+		in     any
+		args   []any
+		err    string
+	}{
+		{"truncate", "hello", nil, "needs a length"},
+		{"truncate", "hello", []any{"x"}, "not a positive number"},
+		{"truncate", "hello", []any{-1.0}, "not a positive number"},
+		{"truncate", "hello", []any{math.NaN()}, "not a positive number"},
+		{"truncate", "hello", []any{math.Inf(1)}, "not a positive number"},
+		{"truncate", "hello", []any{"NaN"}, "not a positive number"},
+		{"truncate", "hello", []any{"Inf"}, "not a positive number"},
+		{"date", "yesterday-ish", nil, "is not a date"},
+		{"default", "", nil, "needs a value"},
+		{"json", map[string]any{"f": func() {}}, nil, "can't convert to json"},
+		{"round", "x", nil, "not a number"},
+		{"round", "NaN", nil, "not a number"},
+		{"round", 1.0, []any{"x"}, "round places"},
+		{"round", 1.0, []any{-1.0}, "round places"},
+		{"round", 1.0, []any{1e9}, "round places"},
+		{"round", 1.0, []any{math.NaN()}, "round places"},
+	}
+
+	for _, tt := range tests {
+		_, err := exprFilters[tt.filter](tt.in, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s(%v, %v): error %v, want %q", tt.filter, tt.in, tt.args, err, tt.err)
+		}
+	}
+}
+
+// A length taken from the query must never crash the page
+func TestTruncateFromQuery(t *testing.T) {
+	for _, n := range []string{"1e20", "NaN", "Inf", "-Inf", "-1", "abc"} {
+		ctx := testContext(t, t.TempDir(), "title=hello&n="+n)
+		out := ParseAndApply("<p>{{ title | truncate:n }}</p>", ctx)
+		if n == "1e20" && out != "<p>hello</p>" {
+			t.Errorf("n=%s: got %q", n, out)
+		}
+		if n != "1e20" && !strings.Contains(out, "inline-error") {
+			t.Errorf("n=%s: expected an inline error, got %q", n, out)
+		}
+	}
+}
+
+// Non-finite numbers from the query are text, arithmetic never prints NaN or Inf
+func TestNonFiniteNumbers(t *testing.T) {
+	tests := []struct {
+		query string
+		page  string
+		want  string
+	}{
+		{"n=NaN", "<p>{{ n + 1 }}</p>", "<p>NaN1</p>"},
+		{"n=Inf", "<p>{{ n * 2 }}</p>", "inline-error"},
+		{"n=-Infinity", "<p>{{ n - 1 }}</p>", "inline-error"},
+		{"n=1e999", "<p>{{ n / 2 }}</p>", "inline-error"},
+		{"n=1e308", "<p>{{ n * 10 }}</p>", "inline-error"},
+		{"n=1e308", "<p>{{ n | round:2 }}</p>", "<p>" + strconv.FormatFloat(1e308, 'f', 2, 64) + "</p>"},
+		{"n=2.345", "<p>{{ n | round:2 }}</p>", "<p>2.35</p>"},
+	}
+	for _, tt := range tests {
+		out := ParseAndApply(tt.page, testContext(t, t.TempDir(), tt.query))
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("?%s %s = %q, want %q", tt.query, tt.page, out, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package directives
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"zin-engine/model"
+)
+
+// testContext is a request for a site at root with the given query string, inline errors are shown
+func testContext(t *testing.T, root string, query string) *model.RequestContext {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.RequestContext{
+		Context:       context.Background(),
+		ClientIp:      "203.0.113.7",
+		Method:        "GET",
+		Host:          "example.test",
+		Path:          "/",
+		Root:          root,
+		ServerVersion: "zin/test",
+		ServerError:   make(map[string]string),
+		Query:         values,
+		Headers:       make(map[string]string),
+		CustomVar: model.CustomVar{
+			Raw:  make(map[string]string),
+			JSON: make(map[string]map[string]any),
+			LIST: make(map[string][]any),
+		},
+		ENV:          make(map[string]string),
+		LocalVar:     make(map[string]string),
+		InlineErrors: true,
+	}
+}
@@ -2,8 +2,6 @@ package directives
 
 import (
 	"fmt"
-	"path/filepath"
-	"regexp"
 	"strings"
 	"zin-engine/model"
 	"zin-engine/utils"
@@ -11,93 +9,103 @@ import (
 
 const maxIncludeDepth = 5
 
-var (
-	zinIncludeRegex         = regexp.MustCompile(`<zin-include\s+file="([^"]+)"(?:\s+type="([^"]+)")?\s*/?>`)
-	fallbackZinIncludeRegex = regexp.MustCompile(`<zin-include\b[^>]*\/?>`)
-)
+var zinIncludeTagExample = `<zin-include file="path/to/file.html" type="raw" />`
+
+// IncludeDirective expands every zin-include of the page, a file can only be included once per page
+func IncludeDirective(nodes []Node, ctx *model.RequestContext) []Node {
+	return newIncluder(ctx).apply(nodes, 0)
+}
 
-func IncludeDirective(content string, ctx *model.RequestContext) string {
+// ApplyIncludes expands zin-include tags and returns every file it tried to read
+func ApplyIncludes(content string, ctx *model.RequestContext) (string, []string) {
 
 	// No include directive, return unchanged
 	if !strings.Contains(content, "<zin-include") {
-		return content
+		return content, nil
 	}
 
-	// Included file-content in raw-content
-	content = processZinIncludes(ctx, content, 0, nil)
+	inc := newIncluder(ctx)
+	nodes := inc.apply(Parse(content), 0)
 
-	// Remove malformed, incomplete, or unhandled zin-includes
-	content = removeUnparsedZinIncludes(content)
+	files := make([]string, 0, len(inc.seen))
+	for file := range inc.seen {
+		files = append(files, file)
+	}
 
-	return content
+	return Render(nodes), files
 }
 
-func processZinIncludes(ctx *model.RequestContext, input string, depth int, seen map[string]bool) string {
-	if depth > maxIncludeDepth {
-		return input // prevent infinite recursion
-	}
-	if seen == nil {
-		seen = make(map[string]bool)
-	}
+// includer carries the recursion state of one page
+type includer struct {
+	ctx  *model.RequestContext
+	seen map[string]bool
+}
 
-	return zinIncludeRegex.ReplaceAllStringFunc(input, func(match string) string {
-		parts := zinIncludeRegex.FindStringSubmatch(match)
-		if len(parts) < 2 {
-			return match
-		}
+func newIncluder(ctx *model.RequestContext) *includer {
+	return &includer{ctx: ctx, seen: make(map[string]bool)}
+}
 
-		includedFile := parts[1]
-		formatter := strings.ToUpper(parts[2])
-		fileType := utils.GetFileType(includedFile)
+func (inc *includer) apply(nodes []Node, depth int) []Node {
+	return applyElementPhase(nodes, inc.ctx, "zin-include", func(el *ElementNode, ctx *model.RequestContext) []Node {
+		return inc.include(el, depth)
+	})
+}
 
-		if fileType == "" {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", match), fmt.Sprintf("Unsupported file '%s' type. Only .html, .css, .js, .md, and .txt files are allowed.", includedFile))
-		}
+func (inc *includer) include(el *ElementNode, depth int) []Node {
+	ctx := inc.ctx
 
-		// Prevent circular includes
-		uniqueKey := filepath.Join(ctx.Root, includedFile)
-		if seen[uniqueKey] {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", match), fmt.Sprintf("File '%s' is already included at recursion depth %d", includedFile, depth))
-		}
-		seen[uniqueKey] = true
+	// Remove malformed, incomplete, or too deeply nested zin-includes
+	includedFile, ok := el.Attr("file")
+	if !ok || includedFile == "" {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Removed malformed <zin-include>: %s. Example: %s", el.Raw, zinIncludeTagExample))
+	}
+	if depth > maxIncludeDepth {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Removed <zin-include>: %s, includes can only be nested %d levels deep", el.Raw, maxIncludeDepth))
+	}
 
-		content, err := utils.GetFileContent(uniqueKey)
-		if err != nil {
-			return SetInlineError(fmt.Sprintf("Failed To Load: %s", match), fmt.Sprintf("Failed to read file '%s': %v", includedFile, err))
-		}
+	formatter, _ := el.Attr("type")
+	formatter = strings.ToUpper(formatter)
+	fileType := utils.GetFileType(includedFile)
 
-		// Recursively process includes in the included file
-		content = processZinIncludes(ctx, content, depth+1, seen)
+	if fileType == "" {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Unsupported file '%s' type. Only .html, .css, .js, .md, and .txt files are allowed.", includedFile))
+	}
 
-		if formatter == "RAW" {
-			if fileType == "HTML" {
-				return utils.SanitizeHTML(content)
-			}
-			return content
-		}
+	// Prevent circular includes
+	uniqueKey, err := utils.ResolveSitePath(ctx, includedFile)
+	if err != nil {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Refused to include '%s': %v", includedFile, err))
+	}
+	if inc.seen[uniqueKey] {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("File '%s' is already included at recursion depth %d", includedFile, depth))
+	}
+	inc.seen[uniqueKey] = true
+
+	content, err := utils.GetFileContent(uniqueKey)
+	if err != nil {
+		return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Failed to read file '%s': %v", includedFile, err))
+	}
+
+	// Recursively process includes in the included file
+	content = Render(inc.apply(Parse(content), depth+1))
 
-		switch fileType {
-		case "HTML":
-			return content
-		case "CSS":
-			return "<style>\n" + content + "\n</style>"
-		case "JS":
-			return "<script type=\"text/javascript\">\n" + content + "\n</script>"
-		case "TXT":
-			return content
-		case "MD":
-			return utils.ParseMdToHTML(content)
-		default:
-			return ""
+	if formatter == "RAW" {
+		if fileType == "HTML" {
+			content = utils.SanitizeHTML(content)
 		}
-	})
-}
+		return Parse(content)
+	}
 
-func removeUnparsedZinIncludes(input string) string {
-	warning := ""
-	matches := fallbackZinIncludeRegex.FindAllString(input, -1)
-	for _, tag := range matches {
-		warning = SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Removed malformed <zin-include>: %s", tag))
+	switch fileType {
+	case "HTML", "TXT":
+		return Parse(content)
+	case "CSS":
+		return Parse("<style>\n" + content + "\n</style>")
+	case "JS":
+		return Parse("<script type=\"text/javascript\">\n" + content + "\n</script>")
+	case "MD":
+		return Parse(utils.ParseMdToHTML(content))
+	default:
+		return nil
 	}
-	return fallbackZinIncludeRegex.ReplaceAllString(input, warning)
 }
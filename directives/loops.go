@@ -2,74 +2,215 @@ package directives
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
+	"sort"
 	"zin-engine/model"
+	"zin-engine/utils"
 )
 
-var (
-	repeatTagRegex   = regexp.MustCompile(`(?s)<zin-repeat\s+for="([^"]+)">(.*?)</zin-repeat>`)
-	variableRegex    = regexp.MustCompile(`{{\s*([a-zA-Z0-9_]+)(\s*\|\|\s*(.*?))?\s*}}`)
-	repeatTagExample = `<zin-repeat for="key"><p>Name: {{ name }} </p></zin-repeat>`
-)
+var repeatTagExample = `<zin-repeat for="posts" as="post"><p>{{ loop.index }}: {{ post.title }}</p><zin-empty>No posts yet</zin-empty></zin-repeat>`
+
+// loopDirective processes zin-repeat directives, nested repeats are expanded with their parent's item in scope
+func loopDirective(el *ElementNode, ctx *model.RequestContext) []Node {
+	return expandLoop(el, newScope(ctx))
+}
+
+// expandLoop repeats the body once per item of the list, object or path named by `for`.
+// Each pass sees the item as `as` (or its fields and `item` without it), `loop` and every outer variable.
+// where, sort-by, order, offset, limit and per-page pick the items, see selectLoopEntries.
+func expandLoop(el *ElementNode, scope *Scope) []Node {
+	ctx := scope.ctx
+	varName, ok := el.Attr("for")
+	if el.Err != "" || !ok || varName == "" {
+		reason := el.Err
+		if reason == "" {
+			reason = fmt.Sprintf("The <zin-repeat> tag is invalid. It must contain a 'for' attribute referencing a predefined list variable, and child elements to repeat. Example: %s", repeatTagExample)
+		}
+		return inlineError(ctx, "Failed To Load: <zin-repeat ... > ... </zin-repeat>", reason)
+	}
+
+	value, found := scope.LookupData(varName)
+	if !found {
+		return inlineError(ctx, "Failed To Load: <zin-repeat ... > ... </zin-repeat>", fmt.Sprintf(`Variable '%s' not found or is not iterable.`, varName))
+	}
+	items, keys, ok := loopItems(value)
+	if !ok {
+		return inlineError(ctx, "Failed To Load: <zin-repeat ... > ... </zin-repeat>", fmt.Sprintf(`Variable '%s' is not a list or an object.`, varName))
+	}
+
+	body, empty := splitEmptyBlock(el.Children)
+	alias, _ := el.Attr("as")
+	var parentLoop any
+	if scope.isLocal("loop") {
+		parentLoop, _ = scope.Lookup("loop")
+	}
+
+	entries := make([]loopEntry, len(items))
+	for i, item := range items {
+		vars := map[string]any{"item": item}
+		if keys != nil {
+			vars["key"], vars["value"] = keys[i], item
+		}
+		if alias != "" {
+			vars[alias] = item
+		} else if obj, isMap := item.(map[string]any); isMap {
+			for k, v := range obj {
+				vars[k] = v
+			}
+		}
+		entries[i] = loopEntry{vars: vars}
+	}
 
-// LoopDirective processes zin-repeat directives in the input content
-func LoopDirectives(content string, ctx *model.RequestContext) string {
-	// No hash directive, return unchanged
-	if !strings.Contains(content, "<zin-repeat") {
-		return content
+	// Filter, sort & page before the body is rendered
+	entries, err := selectLoopEntries(el, entries, scope)
+	if err != nil {
+		return inlineError(ctx, "Failed To Load: <zin-repeat ... > ... </zin-repeat>", err.Error())
+	}
+	if len(entries) == 0 {
+		return empty
 	}
 
-	// Find & replace all zin-repeat tags
-	return repeatTagRegex.ReplaceAllStringFunc(content, func(fullMatch string) string {
-		// Extract the for variable and inner HTML
-		matches := repeatTagRegex.FindStringSubmatch(fullMatch)
-		if len(matches) < 3 {
-			return SetInlineError("Failed To Load: <zin-repeat ... > ... </zin-repeat>", fmt.Sprintf("The <zin-repeat> tag is invalid. It must contain a 'for' attribute referencing a predefined list variable, and child elements to repeat. Example: %s", repeatTagExample))
+	// Loop through each item
+	var out []Node
+	for i, e := range entries {
+		e.vars["loop"] = map[string]any{"index": i, "first": i == 0, "last": i == len(entries)-1, "length": len(entries), "parent": parentLoop}
+
+		// Resolve expressions of this pass in a fresh copy of the body, then its conditions & inner loops
+		itemScope := scope.child(e.vars)
+		pass := substituteLoopItem(cloneNodes(body), itemScope)
+		pass = applyConditionals(pass, itemScope)
+		out = append(out, expandNestedLoops(pass, itemScope)...)
+	}
+	return out
+}
+
+// loopItems gives the items of a list, or the values of an object with their keys in sorted order
+func loopItems(value any) ([]any, []string, bool) {
+	switch v := value.(type) {
+	case []any:
+		return v, nil, true
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		items := make([]any, len(keys))
+		for i, k := range keys {
+			items[i] = v[k]
 		}
+		return items, keys, true
+	}
+	return nil, nil, false
+}
 
-		varName := matches[1]
-		innerHTML := matches[2]
+// splitEmptyBlock separates the <zin-empty> fallback from the repeated body
+func splitEmptyBlock(children []Node) ([]Node, []Node) {
+	var body, empty []Node
+	for _, node := range children {
+		if el, ok := node.(*ElementNode); ok && el.Name == "zin-empty" {
+			empty = append(empty, cloneNodes(el.Children)...)
+			continue
+		}
+		body = append(body, node)
+	}
+	return body, empty
+}
 
-		// Access ctx.CustomVar.LIST[varName]
-		items, ok := ctx.CustomVar.LIST[varName]
+func expandNestedLoops(nodes []Node, scope *Scope) []Node {
+	out := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		el, ok := node.(*ElementNode)
 		if !ok {
-			return SetInlineError("Failed To Load: <zin-repeat ... > ... </zin-repeat>", fmt.Sprintf(`Variable '%s' not found or is not iterable.`, varName))
+			out = append(out, node)
+			continue
 		}
+		if el.Name == "zin-repeat" {
+			out = append(out, expandLoop(el, scope)...)
+			continue
+		}
+		el.Children = expandNestedLoops(el.Children, scope)
+		out = append(out, el)
+	}
+	return out
+}
 
-		var builder strings.Builder
+// Attributes evaluated with the scope of the element, see ElementNode.replaceVariables & parseAttrExpr
+var scopedAttrs = map[string]map[string]bool{
+	"zin-if":     {"cond": true},
+	"zin-elseif": {"cond": true},
+	"zin-set":    {"value": true},
+	"zin-crypt":  {"data": true, "salt": true, "key": true},
+	"zin-data":   {"src": true, "body": true, "headers": true},
+	"zin-form":   {"action": true},
+}
 
-		// Loop through each item in the array
-		for _, item := range items {
-			obj, isMap := item.(map[string]interface{})
-			if !isMap {
-				builder.WriteString(`not-an-object`)
+// substituteLoopItem resolves expressions that read loop variables, nested repeats keep their own scope
+func substituteLoopItem(nodes []Node, scope *Scope) []Node {
+	// Attributes of zin tags are read by directives, only printed values are escaped
+	replace := func(expr string, raw string) string {
+		if val, ok := resolveLoopExpr(expr, scope); ok {
+			return valueText(val)
+		}
+		return raw
+	}
+
+	for i, node := range nodes {
+		switch n := node.(type) {
+		case *ExprNode:
+			if val, ok := resolveLoopExpr(n.Expr, scope); ok {
+				nodes[i] = &TextNode{Pos: n.Pos, Text: escapeValue(n.Context, val)}
+			}
+		case *ElementNode:
+			if n.Name == "zin-repeat" {
 				continue
 			}
-
-			// Replace {{key}} or {{key || "default"}} inside the innerHTML
-			parsed := variableRegex.ReplaceAllStringFunc(innerHTML, func(varExpr string) string {
-				subMatches := variableRegex.FindStringSubmatch(varExpr)
-				if len(subMatches) < 2 {
-					return "undefined"
-				}
-
-				key := subMatches[1]
-				defaultVal := "undefined"
-				if len(subMatches) >= 4 && strings.TrimSpace(subMatches[3]) != "" {
-					defaultVal = strings.Trim(subMatches[3], `"`)
+			// Query values are bound by the directive, never pasted into the SQL
+			n.scope = scope
+			if src, _ := n.Attr("src"); n.Name == "zin-data" && isSQLSource(src) {
+				continue
+			}
+			// Conditions and attributes the directive resolves itself read the item through n.scope,
+			// a value pasted in as text could be evaluated again as an expression
+			n.Raw = replaceExpressions(n.Raw, replace)
+			for j, attr := range n.Attrs {
+				if !scopedAttrs[n.Name][attr.Name] {
+					n.Attrs[j].Value = replaceExpressions(attr.Value, replace)
 				}
+			}
+			n.Children = substituteLoopItem(n.Children, scope)
+		}
+	}
+	return nodes
+}
 
-				val, exists := obj[key]
-				if !exists {
-					return defaultVal
-				}
-				return fmt.Sprintf("%v", val)
-			})
+// resolveLoopExpr evaluates expressions using a loop variable, e.g. {{ post.title | upper }} or {{ price * qty }}.
+// Expressions that only read page variables are left for later.
+func resolveLoopExpr(expr string, scope *Scope) (any, bool) {
 
-			builder.WriteString(parsed)
+	// `key` and `key || "default"` keep their meaning, the default is used when the item has no such key
+	if key, defaultVal, ok := legacyExpression(expr); ok {
+		if !scope.isLocal(utils.SplitKeyPath(key)[0]) {
+			return nil, false
 		}
+		if val, found := scope.Lookup(key); found {
+			return val, true
+		}
+		return defaultVal, true
+	}
 
-		return builder.String()
-	})
+	parsed, err := parseExpr(expr)
+	if err != nil {
+		return nil, false
+	}
+	for _, root := range exprRoots(parsed) {
+		if scope.isLocal(root) {
+			val, err := parsed.eval(scope)
+			if err != nil {
+				return nil, true
+			}
+			return val, true
+		}
+	}
+	return nil, false
 }
@@ -0,0 +1,89 @@
+package directives
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderLoop renders page with a posts list, pages starting with <!--document--> use DIRECTIVE_ORDER=document
+func renderLoop(t *testing.T, query string, page string) string {
+	t.Helper()
+	ctx := testContext(t, t.TempDir(), query)
+	ctx.ENV["SECRET"] = "hunter2"
+	ctx.CustomVar.LIST["posts"] = []any{
+		map[string]any{"title": "Go", "cat": "news", "price": 10.0},
+		map[string]any{"title": "x' || true || '", "cat": "blog", "price": 20.0},
+		map[string]any{"title": "{{ process.env.SECRET }}", "cat": "blog", "price": 30.0},
+	}
+	if strings.HasPrefix(page, "<!--document-->") {
+		ctx.ENV["DIRECTIVE_ORDER"] = "document"
+	}
+	return ParseAndApply(page, ctx)
+}
+
+func TestLoopWhereValuesAreNotCode(t *testing.T) {
+	tests := []struct {
+		query string
+		page  string
+		want  string
+	}{
+		{"cat=news", `<zin-repeat for="posts" as="p" where="p.cat == '{{ cat }}'">[{{ p.title }}]</zin-repeat>`, "[Go]"},
+		{"cat=x') || true || ('", `<zin-repeat for="posts" as="p" where="p.cat == '{{ cat }}'">[{{ p.title }}]</zin-repeat>`, ""},
+		{`cat=x" || true || "`, `<zin-repeat for="posts" as="p" where='p.cat == "{{ cat }}"'>[{{ p.title }}]</zin-repeat>`, ""},
+		{"max=15", `<zin-repeat for="posts" as="p" where="p.price < {{ max }}">[{{ p.cat }}]</zin-repeat>`, "[news]"},
+		{"max=0 || true", `<zin-repeat for="posts" as="p" where="p.price < {{ max }}">[{{ p.cat }}]</zin-repeat>`, ""},
+		{"key=price", `<zin-repeat for="posts" as="p" sort-by="p.cat ~ '{{ key }}'" order="desc" limit="1">[{{ p.price }}]</zin-repeat>`, "[10]"},
+		{"key=') || ('", `<zin-repeat for="posts" as="p" sort-by="p.cat ~ '{{ key }}'" limit="1">[{{ p.price }}]</zin-repeat>`, "[20]"},
+	}
+
+	for _, tt := range tests {
+		if got := renderLoop(t, tt.query, tt.page); got != tt.want {
+			t.Errorf("%s with %q = %q, want %q", tt.page, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLoopItemValuesAreNotCode(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		// A title with quotes & operators stays a value in a nested condition
+		{`<zin-repeat for="posts" as="p"><zin-if cond="'{{ p.title }}' == 'nope'">[{{ p.title }}]</zin-if></zin-repeat>`, ""},
+		{`<zin-repeat for="posts" as="p"><zin-if cond="'{{ p.title }}' == 'Go'">[{{ p.title }}]</zin-if></zin-repeat>`, "[Go]"},
+		{`<zin-repeat for="posts" as="p"><zin-if cond="p.price > 15">[{{ p.cat }}]</zin-if></zin-repeat>`, "[blog][blog]"},
+
+		// An item holding {{ }} is never evaluated by the directive it is passed to
+		{`<!--document--><zin-repeat for="posts" as="p" where="p.price > 25"><zin-set key="t" value="{{ p.title }}" />[{{ t }}]</zin-repeat>`, "<!--document-->[{{ process.env.SECRET }}]"},
+		{`<!--document--><zin-repeat for="posts" as="p" where="p.price > 25"><zin-crypt action="hash" algorithm="md5" data="{{ p.title }}" /></zin-repeat>`, "<!--document-->07c1336986ae3e085db2e917712a4b8b"},
+	}
+
+	for _, tt := range tests {
+		got := renderLoop(t, "", tt.page)
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.page, got, tt.want)
+		}
+		if strings.Contains(got, "hunter2") {
+			t.Errorf("%s leaked a value of .env: %q", tt.page, got)
+		}
+	}
+}
+
+func TestLoopSourceIgnoresQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		page  string
+		want  string
+	}{
+		{"posts=1", `<zin-repeat for="posts" as="p" limit="1">[{{ p.title }}]</zin-repeat>`, "[Go]"},
+		{"posts=1&posts=2", `<zin-repeat for="posts" as="p" limit="1">[{{ posts }}]</zin-repeat>`, "[1]"},
+		{"tags=x", `<zin-repeat for="tags">[{{ item }}]</zin-repeat>`, "not found"},
+		{"", `<zin-repeat for="posts" as="p" limit="1"><zin-repeat for="p.title">x</zin-repeat></zin-repeat>`, "not a list"},
+	}
+
+	for _, tt := range tests {
+		if got := renderLoop(t, tt.query, tt.page); !strings.Contains(got, tt.want) {
+			t.Errorf("?%s %s = %q, want %q", tt.query, tt.page, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package directives
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"zin-engine/utils"
+)
+
+// loopEntry is one item of a zin-repeat with the variables its body will see
+type loopEntry struct {
+	vars map[string]any
+}
+
+// selectLoopEntries applies where, sort-by & order, offset, limit and page / per-page, in that order.
+// With per-page set the pagination metadata is stored in the variable named by `pager` (default "pager").
+func selectLoopEntries(el *ElementNode, entries []loopEntry, scope *Scope) ([]loopEntry, error) {
+	if where, _ := el.Attr("where"); strings.TrimSpace(where) != "" {
+		cond, condScope, err := parseAttrExpr(where, scope)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'where' condition '%s': %v", where, err)
+		}
+		kept := entries[:0:0]
+		for _, e := range entries {
+			val, err := cond.eval(condScope.child(e.vars))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid 'where' condition '%s': %v", where, err)
+			}
+			if truthy(val) {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+
+	order := strings.ToLower(loopAttr(el, "order", scope))
+	if order != "" && order != "asc" && order != "desc" {
+		return nil, fmt.Errorf("Invalid 'order' value '%s'. Use asc or desc", order)
+	}
+	if sortBy, _ := el.Attr("sort-by"); strings.TrimSpace(sortBy) != "" {
+		key, keyScope, err := parseAttrExpr(sortBy, scope)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'sort-by' value '%s': %v", sortBy, err)
+		}
+		values := make([]any, len(entries))
+		for i, e := range entries {
+			if values[i], err = key.eval(keyScope.child(e.vars)); err != nil {
+				return nil, fmt.Errorf("Invalid 'sort-by' value '%s': %v", sortBy, err)
+			}
+		}
+		idx := make([]int, len(entries))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool {
+			if order == "desc" {
+				return compareValues(values[idx[a]], values[idx[b]]) > 0
+			}
+			return compareValues(values[idx[a]], values[idx[b]]) < 0
+		})
+		sorted := make([]loopEntry, len(entries))
+		for i, j := range idx {
+			sorted[i] = entries[j]
+		}
+		entries = sorted
+	} else if order == "desc" {
+		reversed := make([]loopEntry, len(entries))
+		for i, e := range entries {
+			reversed[len(entries)-1-i] = e
+		}
+		entries = reversed
+	}
+
+	offset, err := loopNumber(el, "offset", scope, 0)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[min(offset, len(entries)):]
+
+	limit, err := loopNumber(el, "limit", scope, 0)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	perPage, err := loopNumber(el, "per-page", scope, 0)
+	if err != nil || perPage == 0 {
+		return entries, err
+	}
+	return paginate(el, entries, perPage, scope)
+}
+
+// paginate keeps one page of entries, the page number comes from `page` or the query param named by `page-param`
+func paginate(el *ElementNode, entries []loopEntry, perPage int, scope *Scope) ([]loopEntry, error) {
+	ctx := scope.ctx
+	param := loopAttr(el, "page-param", scope)
+	if param == "" {
+		param = "page"
+	}
+
+	page := 1
+	if raw := loopAttr(el, "page", scope); raw != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'page' value '%s'. It must be a number", raw)
+		}
+		page = n
+	} else {
+		raw, _ := utils.QueryValue(ctx, param)
+		if n, err := strconv.Atoi(raw); err == nil {
+			page = n
+		}
+	}
+
+	total := len(entries)
+	pages := max((total+perPage-1)/perPage, 1)
+	page = min(max(page, 1), pages)
+
+	start := (page - 1) * perPage
+	query := utils.QueryValues(ctx) // the links keep every other param
+	end := min(start+perPage, total)
+
+	pager := map[string]any{
+		"page":      page,
+		"per_page":  perPage,
+		"total":     total,
+		"pages":     pages,
+		"from":      min(start+1, total),
+		"to":        end,
+		"has_prev":  page > 1,
+		"has_next":  page < pages,
+		"prev_url":  "",
+		"next_url":  "",
+		"first_url": pageURL(ctx.Path, query, param, 1),
+		"last_url":  pageURL(ctx.Path, query, param, pages),
+	}
+	if page > 1 {
+		pager["prev_url"] = pageURL(ctx.Path, query, param, page-1)
+	}
+	if page < pages {
+		pager["next_url"] = pageURL(ctx.Path, query, param, page+1)
+	}
+
+	name := loopAttr(el, "pager", scope)
+	if name == "" {
+		name = "pager"
+	}
+	ctx.CustomVar.JSON[name] = pager
+
+	return entries[start:end], nil
+}
+
+// pageURL is the current path & query with the page param set to page
+func pageURL(path string, query url.Values, param string, page int) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set(param, strconv.Itoa(page))
+	return path + "?" + q.Encode()
+}
+
+// loopAttr reads an attribute of a zin-repeat, {{ }} in it are resolved with the outer scope.
+// Only for values checked afterwards, where & sort-by are expressions and use parseAttrExpr.
+func loopAttr(el *ElementNode, name string, scope *Scope) string {
+	value, _ := el.Attr(name)
+	return replaceExpressions(value, func(expr string, raw string) string {
+		val, err := resolveValue(scope, expr)
+		if err != nil {
+			return "undefined"
+		}
+		return valueText(val)
+	})
+}
+
+// loopNumber reads a non negative number attribute
+func loopNumber(el *ElementNode, name string, scope *Scope, defaultVal int) (int, error) {
+	raw := strings.TrimSpace(loopAttr(el, name, scope))
+	if raw == "" {
+		return defaultVal, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid '%s' value '%s'. It must be a whole number, 0 or more", name, raw)
+	}
+	return n, nil
+}
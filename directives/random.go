@@ -3,54 +3,36 @@ package directives
 import (
 	"fmt"
 	"math/rand"
-	"regexp"
 	"strconv"
 	"strings"
-	"time"
 	"zin-engine/model"
 )
 
-var (
-	zinRandomRegex         = regexp.MustCompile(`<zin-random(?:\s+type="([^"]*)")?(?:\s+len="([^"]*)")?\s*/?>`)
-	fallbackZinRandomRegex = regexp.MustCompile(`<zin-random\b[^>]*\/?>`)
-	seededRand             = rand.New(rand.NewSource(time.Now().UnixNano()))
-	zinRandomTagExample    = `<zin-random type="int|string|mix|special" length="10" />`
-)
-
-func RandomDirective(content string, ctx *model.RequestContext) string {
-
-	// No random directive, return unchanged
-	if !strings.Contains(content, "<zin-random") {
-		return content
-	}
-
-	// Replace all matches
-	content = zinRandomRegex.ReplaceAllStringFunc(content, func(match string) string {
-		submatches := zinRandomRegex.FindStringSubmatch(match)
+var zinRandomTagExample = `<zin-random type="int|string|mix|special" len="10" />`
 
-		randType := "ANY"
-		length := 5
+func randomDirective(el *ElementNode, ctx *model.RequestContext) []Node {
+	ctx.Uncacheable = true
 
-		if len(submatches) >= 2 && submatches[1] != "" {
-			randType = strings.ToUpper(submatches[1])
-		}
+	randType := "ANY"
+	length := 5
 
-		if len(submatches) >= 3 && submatches[2] != "" {
-			if _length, err := strconv.Atoi(submatches[2]); err == nil {
+	for _, attr := range el.Attrs {
+		switch attr.Name {
+		case "type":
+			if attr.Value != "" {
+				randType = strings.ToUpper(attr.Value)
+			}
+		case "len", "length":
+			if _length, err := strconv.Atoi(attr.Value); err == nil && _length >= 0 {
 				length = _length
 			}
+		default:
+			return inlineError(ctx, fmt.Sprintf("Failed To Load: %s", el.Raw), fmt.Sprintf("Invalid  `zin-random` tag — use format: %s, with optional, single-use 'type' and 'len' attributes.", zinRandomTagExample))
 		}
+	}
 
-		// Call your custom generator
-		return GenerateRandom(randType, length)
-	})
-
-	// Check if any random-tag still left in content
-	content = fallbackZinRandomRegex.ReplaceAllStringFunc(content, func(tag string) string {
-		return SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Invalid  `zin-random` tag — use format: %s, with optional, single-use 'type' and 'length' attributes.", zinRandomTagExample))
-	})
-
-	return content
+	// Call your custom generator
+	return textNodes(GenerateRandom(randType, length))
 }
 
 func GenerateRandom(kind string, length int) string {
@@ -69,9 +51,10 @@ func GenerateRandom(kind string, length int) string {
 		charset = "abcdefghijklmnopqrstuvwxyz0123456789"
 	}
 
+	// Package-level rand functions are safe for concurrent requests
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+		b[i] = charset[rand.Intn(len(charset))]
 	}
 	return string(b)
 }
@@ -0,0 +1,74 @@
+package directives
+
+import (
+	"strings"
+	"zin-engine/model"
+	"zin-engine/utils"
+)
+
+// Scope resolves names for expressions, loop variables shadow the request context
+type Scope struct {
+	ctx    *model.RequestContext
+	vars   map[string]any
+	parent *Scope
+}
+
+func newScope(ctx *model.RequestContext) *Scope {
+	return &Scope{ctx: ctx}
+}
+
+// child returns a scope where vars are looked up before s
+func (s *Scope) child(vars map[string]any) *Scope {
+	return &Scope{ctx: s.ctx, vars: vars, parent: s}
+}
+
+// Lookup resolves a path like user.name, users[0].email or process.env.KEY
+func (s *Scope) Lookup(path string) (any, bool) {
+	if strings.HasPrefix(path, "process.env.") {
+		key := strings.ToUpper(strings.TrimPrefix(path, "process.env."))
+		return utils.LookupValue(s.ctx, key, true)
+	}
+
+	parts := utils.SplitKeyPath(path)
+	for sc := s; sc != nil; sc = sc.parent {
+		if val, ok := sc.vars[parts[0]]; ok {
+			return utils.ResolvePath(val, parts[1:])
+		}
+	}
+	return utils.LookupValue(s.ctx, path, false)
+}
+
+// LookupData resolves the source of a loop, from loop variables, zin-set values and loaded data only.
+// Query params are left out so a visitor can't shadow a list with ?posts=1.
+func (s *Scope) LookupData(path string) (any, bool) {
+	parts := utils.SplitKeyPath(path)
+	if len(parts) == 0 {
+		return nil, false
+	}
+	for sc := s; sc != nil; sc = sc.parent {
+		if val, ok := sc.vars[parts[0]]; ok {
+			return utils.ResolvePath(val, parts[1:])
+		}
+	}
+
+	if val, ok := s.ctx.LocalVar[path]; ok {
+		return val, true
+	}
+	if list, ok := s.ctx.CustomVar.LIST[parts[0]]; ok {
+		return utils.ResolvePath(list, parts[1:])
+	}
+	if data, ok := s.ctx.CustomVar.JSON[parts[0]]; ok {
+		return utils.ResolvePath(data, parts[1:])
+	}
+	return nil, false
+}
+
+// isLocal reports if name is a loop variable of s or one of its parents
+func (s *Scope) isLocal(name string) bool {
+	for sc := s; sc != nil; sc = sc.parent {
+		if _, ok := sc.vars[name]; ok {
+			return true
+		}
+	}
+	return false
+}
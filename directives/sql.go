@@ -0,0 +1,181 @@
+package directives
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"zin-engine/utils"
+)
+
+// sqlDialect is what bindSQL needs to know about the driver of a connection
+type sqlDialect struct {
+	placeholder  func(n int) string // the n-th (from 1) parameter in the driver's syntax, ? or $n
+	hashComment  bool               // # starts a comment, mysql only
+	questionMark bool               // ? is a parameter, postgres uses it in jsonb operators instead
+}
+
+func dialectOf(conn *utils.SQLConn) sqlDialect {
+	return sqlDialect{
+		placeholder:  conn.Placeholder,
+		hashComment:  conn.Driver == "mysql",
+		questionMark: conn.Driver != "postgres",
+	}
+}
+
+// bindSQL turns the placeholders of a query into driver arguments, values are never pasted into the SQL:
+//
+//	{{ expr }}    the value of any expression, e.g. {{ id }} or {{ q | lower }}
+//	'{{ expr }}'  the same, bound as text, so existing WHERE slug = '{{ slug }}' queries keep working
+//	:name         the variable name, e.g. :id or :user.id
+//	?             the next value listed in the params attribute, e.g. params="id, page" (not for postgres)
+//
+// Quoted strings, quoted identifiers and comments are copied as they are, any other {{ }} inside quotes is an error.
+func bindSQL(query string, params []string, scope *Scope, dialect sqlDialect) (string, []any, error) {
+	var b strings.Builder
+	var args []any
+	next := 0
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(query, i)
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated %c quote in query", c)
+			}
+			quoted := query[i+1 : end]
+			if !strings.Contains(quoted, "{{") {
+				b.WriteString(query[i : end+1])
+				i = end
+				continue
+			}
+
+			// A string that is just '{{ expr }}' is bound as text, a value pasted into a longer one isn't supported
+			inner, exprEnd, ok := matchExpression(quoted, 0)
+			if c != '\'' || !strings.HasPrefix(quoted, "{{") || !ok || exprEnd != len(quoted) {
+				return "", nil, fmt.Errorf("{{ }} inside the quoted %s is not supported, use '{{ expr }}' alone or build the text with ~ in the expression", query[i:end+1])
+			}
+			val, err := resolveValue(scope, strings.TrimSpace(inner))
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid expression %s: %v", quoted, err)
+			}
+			args = append(args, toText(val))
+			b.WriteString(dialect.placeholder(len(args)))
+			i = end
+
+		case strings.HasPrefix(query[i:], "--") || c == '#' && dialect.hashComment:
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated /* comment in query")
+			}
+			b.WriteString(query[i : i+end+4])
+			i += end + 3
+
+		case strings.HasPrefix(query[i:], "{{"):
+			inner, end, ok := matchExpression(query, i)
+			if !ok {
+				b.WriteByte(c)
+				continue
+			}
+			val, err := resolveValue(scope, strings.TrimSpace(inner))
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid expression %s: %v", query[i:end], err)
+			}
+			args = append(args, sqlArg(val))
+			b.WriteString(dialect.placeholder(len(args)))
+			i = end - 1
+
+		case c == '?' && dialect.questionMark:
+			if next >= len(params) {
+				return "", nil, fmt.Errorf("the query has more ? placeholders than values in the params attribute")
+			}
+			val, err := resolveValue(scope, params[next])
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid param '%s': %v", params[next], err)
+			}
+			next++
+			args = append(args, sqlArg(val))
+			b.WriteString(dialect.placeholder(len(args)))
+
+		case c == ':' && i+1 < len(query) && (isLetter(query[i+1]) || query[i+1] == '_') && (i == 0 || !isWordChar(query[i-1]) && query[i-1] != ':'):
+			end := i + 1
+			for end < len(query) && (isWordChar(query[end]) || query[end] == '.') {
+				end++
+			}
+			name := strings.TrimRight(query[i+1:end], ".")
+			val, found := scope.Lookup(name)
+			if !found {
+				return "", nil, fmt.Errorf("variable :%s used in the query is not defined", name)
+			}
+			args = append(args, sqlArg(val))
+			b.WriteString(dialect.placeholder(len(args)))
+			i += len(name)
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	if next < len(params) {
+		return "", nil, fmt.Errorf("the params attribute has %d values but the query only uses %d", len(params), next)
+	}
+	return b.String(), args, nil
+}
+
+// closingQuote finds the end of the quoted part starting at start, doubled quotes and \ escapes stay inside
+func closingQuote(query string, start int) int {
+	q := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && q != '`':
+			i++
+		case query[i] == q && i+1 < len(query) && query[i+1] == q:
+			i++
+		case query[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// sqlArg converts a template value into something the driver can bind.
+// Text, like query params, stays text so the column type decides, '007' is never turned into 7.
+// Whole numbers computed by an expression, e.g. {{ page * 10 }}, are bound as integers.
+func sqlArg(v any) any {
+	switch val := v.(type) {
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val)
+		}
+		return val
+	case nil, string, bool, int, int64:
+		return val
+	}
+	return toText(v)
+}
+
+// splitParams reads the params attribute, a comma separated list of expressions
+func splitParams(raw string) []string {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	var params []string
+	for _, p := range strings.Split(raw, ",") {
+		params = append(params, strings.TrimSpace(p))
+	}
+	return params
+}
+
+// isSQLSource reports if a zin-data src runs a query, its {{ }} are bound and not replaced as text
+func isSQLSource(src string) bool {
+	src = strings.TrimSpace(src)
+	return strings.HasPrefix(src, "mysql://") || strings.HasPrefix(src, "sql://")
+}
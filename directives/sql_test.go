@@ -0,0 +1,149 @@
+package directives
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBindSQL(t *testing.T) {
+	ctx := testContext(t, t.TempDir(), "id=007&q=x' OR '1'='1&limit=10&name=abc")
+	scope := newScope(ctx).child(map[string]any{"user": map[string]any{"id": 5.0}, "page": 2.0})
+	question := func(n int) string { return "?" }
+	mysql := sqlDialect{placeholder: question, hashComment: true, questionMark: true}
+	sqlite := sqlDialect{placeholder: question, questionMark: true}
+	postgres := sqlDialect{placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }}
+
+	tests := []struct {
+		query   string
+		params  []string
+		dialect sqlDialect
+		want    string
+		args    []any
+	}{
+		{`SELECT * FROM t WHERE id = {{ id }}`, nil, mysql, `SELECT * FROM t WHERE id = ?`, []any{"007"}},
+		{`SELECT * FROM t WHERE name = :name AND id = :user.id`, nil, postgres, `SELECT * FROM t WHERE name = $1 AND id = $2`, []any{"abc", int64(5)}},
+		{`SELECT * FROM t WHERE a = ? AND b = ?`, []string{"id", "limit"}, sqlite, `SELECT * FROM t WHERE a = ? AND b = ?`, []any{"007", "10"}},
+		{`SELECT * FROM t WHERE q = {{ q }}`, nil, mysql, `SELECT * FROM t WHERE q = ?`, []any{"x' OR '1'='1"}},
+		{`SELECT * FROM t LIMIT {{ page * 10 }}`, nil, mysql, `SELECT * FROM t LIMIT ?`, []any{int64(20)}},
+		{`SELECT * FROM t WHERE price > {{ page / 4 }}`, nil, mysql, `SELECT * FROM t WHERE price > ?`, []any{0.5}},
+
+		// Quotes, identifiers & comments are copied, their ? : and {{ }} are not placeholders
+		{`SELECT 'what?', "a:b", ` + "`c?`" + ` FROM t WHERE id = ?`, []string{"id"}, mysql, `SELECT 'what?', "a:b", ` + "`c?`" + ` FROM t WHERE id = ?`, []any{"007"}},
+		{`SELECT 'it''s', 'a\'?' FROM t`, nil, mysql, `SELECT 'it''s', 'a\'?' FROM t`, nil},
+		{"SELECT 1 -- why? :name\nFROM t /* {{ id }} ? */ WHERE 1 # {{ q }} ?", nil, mysql, "SELECT 1 -- why? :name\nFROM t /* {{ id }} ? */ WHERE 1 # {{ q }} ?", nil},
+		{`SELECT created::date, a:=1 FROM t WHERE x = 'y'`, nil, postgres, `SELECT created::date, a:=1 FROM t WHERE x = 'y'`, nil},
+
+		// A string that is only '{{ expr }}' is bound as text, as pages written for plain replacement expect
+		{`SELECT * FROM t WHERE slug = '{{ q }}' AND id = '{{ user.id }}'`, nil, postgres, `SELECT * FROM t WHERE slug = $1 AND id = $2`, []any{"x' OR '1'='1", "5"}},
+
+		// # and ? are operators outside mysql & sqlite
+		{`SELECT data #> '{a,b}', data #>> '{c}' FROM t WHERE data ? 'k' AND data ?| array['a'] AND data ?& array['b'] AND id = {{ id }}`, nil, postgres,
+			`SELECT data #> '{a,b}', data #>> '{c}' FROM t WHERE data ? 'k' AND data ?| array['a'] AND data ?& array['b'] AND id = $1`, []any{"007"}},
+		{"SELECT a # b FROM t\nWHERE id = ?", []string{"id"}, sqlite, "SELECT a # b FROM t\nWHERE id = ?", []any{"007"}},
+	}
+
+	for _, tt := range tests {
+		got, args, err := bindSQL(tt.query, tt.params, scope, tt.dialect)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.query, err)
+			continue
+		}
+		if got != tt.want || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s:\n got %s %#v\nwant %s %#v", tt.query, got, args, tt.want, tt.args)
+		}
+	}
+}
+
+func TestBindSQLErrors(t *testing.T) {
+	scope := newScope(testContext(t, t.TempDir(), "id=1"))
+	mysql := sqlDialect{placeholder: func(n int) string { return "?" }, hashComment: true, questionMark: true}
+
+	tests := []struct {
+		query  string
+		params []string
+		err    string
+	}{
+		{`SELECT * FROM t WHERE id = :missing`, nil, "variable :missing used in the query is not defined"},
+		{`SELECT * FROM t WHERE id = ?`, nil, "more ? placeholders than values"},
+		{`SELECT * FROM t WHERE id = ?`, []string{"id", "id"}, "params attribute has 2 values but the query only uses 1"},
+		{`SELECT * FROM t WHERE id = {{ id + }}`, nil, "invalid expression"},
+		{`SELECT * FROM t WHERE id = ?`, []string{"id |"}, "invalid param"},
+		{`SELECT 'open FROM t`, nil, "unterminated ' quote"},
+		{`SELECT 1 /* open`, nil, "unterminated /* comment"},
+		{`SELECT * FROM t WHERE name LIKE '%{{ id }}%'`, nil, "inside the quoted '%{{ id }}%' is not supported"},
+		{`SELECT * FROM t WHERE name = 'it''s {{ id }}'`, nil, "is not supported"},
+		{`SELECT * FROM t WHERE name = "{{ id }}"`, nil, "is not supported"},
+		{`SELECT * FROM t WHERE name = '{{ id + }}'`, nil, "invalid expression"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := bindSQL(tt.query, tt.params, scope, mysql)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestSQLMaxRowsTruncates(t *testing.T) {
+	root := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(root, "site.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE items (id INTEGER); INSERT INTO items VALUES (1), (2), (3)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The second render of the cached tag reads the rows & the flag from the cache
+	page := `<zin-data src="sql://main/SELECT id FROM items ORDER BY id" as="items" max-rows="2" cache="1m" />` +
+		`<zin-repeat for="items" as="i">[{{ i.id }}]</zin-repeat>{{ items_truncated }}`
+	for round := 0; round < 2; round++ {
+		ctx := testContext(t, root, "")
+		ctx.InlineErrors = false
+		ctx.ENV["DB_main_DSN"] = "sqlite://site.db"
+		if got := ParseAndApply(page, ctx); got != "[1][2]true" {
+			t.Errorf("round %d = %q, want [1][2]true", round, got)
+		}
+	}
+
+	ctx := testContext(t, root, "")
+	ctx.ENV["DB_main_DSN"] = "sqlite://site.db"
+	got := ParseAndApply(`<zin-data src="sql://main/SELECT id FROM items" as="items" max-rows="5" />{{ items_truncated }}`, ctx)
+	if got != "false" {
+		t.Errorf("a result under max-rows = %q, want false", got)
+	}
+}
+
+func TestSQLQuotedExpressionIsBound(t *testing.T) {
+	root := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(root, "site.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE posts (slug TEXT, title TEXT); INSERT INTO posts VALUES ('hello', 'Hello'), ('x'' OR ''1''=''1', 'Quoted')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	tests := []struct {
+		query string
+		page  string
+		want  string
+	}{
+		{"slug=hello", `<zin-data src="sql://main/SELECT title FROM posts WHERE slug = '{{ slug }}'" as="p" /><zin-repeat for="p">[{{ title }}]</zin-repeat>`, "[Hello]"},
+		{"slug=x' OR '1'='1", `<zin-data src="sql://main/SELECT title FROM posts WHERE slug = '{{ slug }}'" as="p" /><zin-repeat for="p">[{{ title }}]</zin-repeat>`, "[Quoted]"},
+		{"slug=hello", `<zin-data src="sql://main/SELECT title FROM posts WHERE slug LIKE '%{{ slug }}%'" as="p" />`, "is not supported"},
+	}
+	for _, tt := range tests {
+		ctx := testContext(t, root, tt.query)
+		ctx.ENV["DB_main_DSN"] = "sqlite://site.db"
+		if got := ParseAndApply(tt.page, ctx); !strings.Contains(got, tt.want) {
+			t.Errorf("?%s %s = %q, want %q", tt.query, tt.page, got, tt.want)
+		}
+	}
+}
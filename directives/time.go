@@ -2,7 +2,6 @@ package directives
 
 import (
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -10,74 +9,54 @@ import (
 	"zin-engine/utils"
 )
 
-func TimeDirectives(content string, ctx *model.RequestContext) string {
-	// No time directive, return unchanged
-	if !strings.Contains(content, "<zin-time") {
-		return content
-	}
+func timeDirective(el *ElementNode, ctx *model.RequestContext) []Node {
+	ctx.Uncacheable = true
 
 	// TimeZone if configured in env to be used as default
 	timeZone := utils.GetValue(ctx, "TIME_ZONE", "Local", true)
 
-	// Match all self-closing zin-time tags
-	re := regexp.MustCompile(`<zin-time([^>]*)\/>`)
-	matches := re.FindAllStringSubmatch(content, -1)
-
-	for _, match := range matches {
-		fullTag := match[0]
-		attrStr := match[1]
-
-		attrs := parseTimeAttributes(attrStr)
+	attrs := parseTimeAttributes(el)
 
-		when := attrs["when"]
-		view := attrs["view"]
-		tz := attrs["tz"]
+	when := attrs["when"]
+	view := attrs["view"]
+	tz := attrs["tz"]
 
-		// Defaults
-		if when == "" {
-			when = "now"
-		}
-		if view == "" {
-			view = "datetime"
-		}
-
-		// Parse time
-		t, err := parseWhen(when)
-		if err != nil {
-			replacement := SetInlineError(fmt.Sprintf("Failed to load: %s", fullTag), fmt.Sprintf("Invalid when: %v", err))
-			content = strings.Replace(content, fullTag, replacement, 1)
-			continue
-		}
+	// Defaults
+	if when == "" {
+		when = "now"
+	}
+	if view == "" {
+		view = "datetime"
+	}
 
-		// If timezone is not configured in tag then set to default
-		if tz == "" {
-			tz = timeZone
-		}
+	// Parse time
+	t, err := parseWhen(when)
+	if err != nil {
+		return inlineError(ctx, fmt.Sprintf("Failed to load: %s", el.Raw), fmt.Sprintf("Invalid when: %v", err))
+	}
 
-		// Handle timezone
-		if tz != "" {
-			loc, err := time.LoadLocation(tz)
-			if err != nil {
-				replacement := SetInlineError(fmt.Sprintf("Failed to load: %s", fullTag), fmt.Sprintf("Invalid tz: %v", err))
-				content = strings.Replace(content, fullTag, replacement, 1)
-				continue
-			}
-			t = t.In(loc)
-		}
+	// If timezone is not configured in tag then set to default
+	if tz == "" {
+		tz = timeZone
+	}
 
-		// Format view
-		formatted, err := formatView(t, view)
+	// Handle timezone
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
 		if err != nil {
-			replacement := SetInlineError(fmt.Sprintf("Failed to load: %s", fullTag), fmt.Sprintf("Invalid view: %v", err))
-			content = strings.Replace(content, fullTag, replacement, 1)
-			continue
+			return inlineError(ctx, fmt.Sprintf("Failed to load: %s", el.Raw), fmt.Sprintf("Invalid tz: %v", err))
 		}
+		t = t.In(loc)
+	}
 
-		// Replace tag with formatted time
-		content = strings.Replace(content, fullTag, formatted, 1)
+	// Format view
+	formatted, err := formatView(t, view)
+	if err != nil {
+		return inlineError(ctx, fmt.Sprintf("Failed to load: %s", el.Raw), fmt.Sprintf("Invalid view: %v", err))
 	}
 
-	return content
+	// Replace tag with formatted time
+	return textNodes(formatted)
 }
 
 func parseWhen(input string) (time.Time, error) {
@@ -201,14 +180,10 @@ func applyStartOrEnd(part string, t time.Time) time.Time {
 	}
 }
 
-func parseTimeAttributes(attrStr string) map[string]string {
+func parseTimeAttributes(el *ElementNode) map[string]string {
 	attrs := make(map[string]string)
-	re := regexp.MustCompile(`(\w+)="([^"]*)"`)
-	pairs := re.FindAllStringSubmatch(attrStr, -1)
-	for _, pair := range pairs {
-		key := strings.ToLower(pair[1])
-		val := pair[2]
-		attrs[key] = val
+	for _, attr := range el.Attrs {
+		attrs[strings.ToLower(attr.Name)] = attr.Value
 	}
 	return attrs
 }
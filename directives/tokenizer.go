@@ -0,0 +1,286 @@
+package directives
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tags that wrap content and need a closing tag, every other zin tag is void
+var blockTags = map[string]bool{
+	"zin-repeat": true,
+	"zin-if":     true,
+	"zin-elseif": true,
+	"zin-else":   true,
+	"zin-empty":  true,
+	"zin-form":   true,
+}
+
+// posTable converts byte offsets into line & column numbers
+type posTable []int
+
+func newPosTable(src string) posTable {
+	lines := posTable{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+func (t posTable) pos(offset int) Pos {
+	line := sort.Search(len(t), func(i int) bool { return t[i] > offset }) - 1
+	return Pos{Offset: offset, Line: line + 1, Col: offset - t[line] + 1}
+}
+
+// Parse tokenizes content in a single pass into text, {{ }} expressions and zin elements
+func Parse(content string) []Node {
+	p := &tokenizer{src: content, lines: newPosTable(content)}
+	return p.parse()
+}
+
+type tokenizer struct {
+	src   string
+	lines posTable
+	html  htmlState
+}
+
+func (p *tokenizer) parse() []Node {
+	root := &ElementNode{}
+	stack := []*ElementNode{root}
+	textStart := 0
+
+	flushText := func(end int) {
+		if end > textStart {
+			p.html.feed(p.src[textStart:end])
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, &TextNode{Pos: p.lines.pos(textStart), Text: p.src[textStart:end]})
+		}
+	}
+
+	for i := 0; i < len(p.src); {
+		switch {
+		case strings.HasPrefix(p.src[i:], "{{"):
+			inner, end, ok := matchExpression(p.src, i)
+			if !ok {
+				i++
+				continue
+			}
+			flushText(i)
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, &ExprNode{Pos: p.lines.pos(i), Expr: strings.TrimSpace(inner), Raw: p.src[i:end], Context: p.html.context()})
+			p.html.written()
+			i, textStart = end, end
+
+		case strings.HasPrefix(p.src[i:], "</zin"):
+			name, nameEnd := readZinName(p.src, i+2)
+			end := strings.IndexByte(p.src[nameEnd:], '>')
+			if name == "" || end < 0 {
+				i++
+				continue
+			}
+			end += nameEnd + 1
+
+			// Stray closing tags stay in the content as text
+			open := -1
+			for s := len(stack) - 1; s > 0; s-- {
+				if stack[s].Name == name {
+					open = s
+					break
+				}
+			}
+			if open < 0 {
+				i++
+				continue
+			}
+
+			flushText(i)
+			for len(stack)-1 > open {
+				p.unwindUnclosed(&stack)
+			}
+			stack[open].CloseRaw = p.src[i:end]
+			stack = stack[:open]
+			i, textStart = end, end
+
+		case strings.HasPrefix(p.src[i:], "<zin"):
+			el, end, selfClosing, ok := p.parseTag(i)
+			if !ok {
+				i++
+				continue
+			}
+			flushText(i)
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, el)
+			if blockTags[el.Name] && !selfClosing {
+				stack = append(stack, el)
+			}
+			i, textStart = end, end
+
+		default:
+			i++
+		}
+	}
+
+	flushText(len(p.src))
+	for len(stack) > 1 {
+		p.unwindUnclosed(&stack)
+	}
+
+	return root.Children
+}
+
+// unwindUnclosed turns the top block into a malformed void tag and hands its children to the parent
+func (p *tokenizer) unwindUnclosed(stack *[]*ElementNode) {
+	s := *stack
+	el := s[len(s)-1]
+	parent := s[len(s)-2]
+
+	el.Err = fmt.Sprintf("Missing closing </%s> tag for the tag opened at line %d, column %d.", el.Name, el.Line, el.Col)
+	children := el.Children
+	el.Children = nil
+	parent.Children = append(parent.Children, children...)
+
+	*stack = s[:len(s)-1]
+}
+
+// parseTag reads an opening zin tag with its attributes starting at offset start
+func (p *tokenizer) parseTag(start int) (el *ElementNode, end int, selfClosing bool, ok bool) {
+	name, i := readZinName(p.src, start+1)
+	if name == "" {
+		return nil, 0, false, false
+	}
+
+	el = &ElementNode{Pos: p.lines.pos(start), Name: name}
+	for i < len(p.src) {
+		c := p.src[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '>':
+			el.Raw = p.src[start : i+1]
+			return el, i + 1, false, true
+		case c == '/' && i+1 < len(p.src) && p.src[i+1] == '>':
+			el.Raw = p.src[start : i+2]
+			return el, i + 2, true, true
+		default:
+			attr, next := p.parseAttr(i)
+			if next == i {
+				i++
+				continue
+			}
+			el.Attrs = append(el.Attrs, attr)
+			i = next
+		}
+	}
+
+	// Tag never ends, leave it as text
+	return nil, 0, false, false
+}
+
+// parseAttr reads name, name=value, name="value" or name='value'
+func (p *tokenizer) parseAttr(start int) (Attr, int) {
+	i := start
+	for i < len(p.src) && !isSpace(p.src[i]) && !strings.ContainsRune(`="'>`, rune(p.src[i])) && !strings.HasPrefix(p.src[i:], "/>") {
+		i++
+	}
+	attr := Attr{Pos: p.lines.pos(start), Name: p.src[start:i]}
+	if attr.Name == "" {
+		return attr, start
+	}
+
+	// Optional value
+	j := i
+	for j < len(p.src) && isSpace(p.src[j]) {
+		j++
+	}
+	if j >= len(p.src) || p.src[j] != '=' {
+		return attr, i
+	}
+	j++
+	for j < len(p.src) && isSpace(p.src[j]) {
+		j++
+	}
+	if j >= len(p.src) {
+		return attr, j
+	}
+
+	if quote := p.src[j]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(p.src[j+1:], quote)
+		if end < 0 {
+			return attr, len(p.src)
+		}
+		attr.Value = p.src[j+1 : j+1+end]
+		return attr, j + end + 2
+	}
+
+	valueStart := j
+	for j < len(p.src) && !isSpace(p.src[j]) && p.src[j] != '>' && !strings.HasPrefix(p.src[j:], "/>") {
+		j++
+	}
+	attr.Value = p.src[valueStart:j]
+	return attr, j
+}
+
+// readZinName reads a tag name like zin or zin-repeat, "zinc" or "zin_x" are not zin tags
+func readZinName(src string, start int) (string, int) {
+	if !strings.HasPrefix(src[start:], "zin") {
+		return "", start
+	}
+	i := start + 3
+	if i < len(src) && (isWordChar(src[i])) {
+		return "", start
+	}
+	for i < len(src) && (isWordChar(src[i]) || src[i] == '-') {
+		i++
+	}
+	return src[start:i], i
+}
+
+// matchExpression reads {{ ... }} at offset start, braces inside aren't allowed
+func matchExpression(src string, start int) (inner string, end int, ok bool) {
+	close := strings.Index(src[start+2:], "}}")
+	if close < 0 {
+		return "", 0, false
+	}
+	inner = src[start+2 : start+2+close]
+	if inner == "" || strings.ContainsAny(inner, "{}") {
+		return "", 0, false
+	}
+	return inner, start + 2 + close + 2, true
+}
+
+// replaceExpressions rewrites every {{ ... }} in a plain string such as an attribute value
+func replaceExpressions(s string, fn func(expr string, raw string) string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+
+	var builder strings.Builder
+	last := 0
+	for i := 0; i < len(s); {
+		if !strings.HasPrefix(s[i:], "{{") {
+			i++
+			continue
+		}
+		inner, end, ok := matchExpression(s, i)
+		if !ok {
+			i++
+			continue
+		}
+		builder.WriteString(s[last:i])
+		builder.WriteString(fn(strings.TrimSpace(inner), s[i:end]))
+		i, last = end, end
+	}
+	builder.WriteString(s[last:])
+
+	return builder.String()
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,128 @@
+package directives
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// dump prints the tree in a compact form: text as %q, {expr}, and elements as name(children) with ! when malformed
+func dump(nodes []Node) string {
+	parts := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		switch v := n.(type) {
+		case *TextNode:
+			parts = append(parts, fmt.Sprintf("%q", v.Text))
+		case *ExprNode:
+			parts = append(parts, "{"+v.Expr+"}")
+		case *ElementNode:
+			name := v.Name
+			if v.Err != "" {
+				name += "!"
+			}
+			parts = append(parts, name+"("+dump(v.Children)+")")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseTree(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`a {{ x }} b`, `"a " {x} " b"`},
+		{`<zin-repeat for="p"><zin-if cond="a">{{ b }}</zin-if></zin-repeat>`, `zin-repeat(zin-if({b}))`},
+		{`<zin-if cond="a > 1">x</zin-if>y`, `zin-if("x") "y"`},
+		{`<zin-set key="a" value="b" />{{ a }}`, `zin-set() {a}`},
+		{`<zin-set key="a" value="b">{{ a }}`, `zin-set() {a}`},
+
+		// Unclosed blocks keep their place and hand their children to the parent
+		{`<zin-repeat for="p"><p>{{ x }}`, `zin-repeat!() "<p>" {x}`},
+		{`<zin-repeat for="p"><zin-if cond="a">x</zin-repeat>y`, `zin-repeat(zin-if!() "x") "y"`},
+		{`<zin-if cond="a"><zin-if cond="b">x</zin-if>`, `zin-if!() zin-if("x")`},
+
+		// Stray closers, unterminated tags and broken expressions stay text
+		{`x</zin-if>y`, `"x</zin-if>y"`},
+		{`<zin-set key="a"`, `"<zin-set key=\"a\""`},
+		{`{{ a`, `"{{ a"`},
+		{`{{}}`, `"{{}}"`},
+	}
+
+	for _, tt := range tests {
+		if got := dump(Parse(tt.src)); got != tt.want {
+			t.Errorf("Parse(%s) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseUnclosedError(t *testing.T) {
+	nodes := Parse("<p>\n  <zin-repeat for=\"p\">{{ x }}")
+	el, ok := nodes[1].(*ElementNode)
+	if !ok {
+		t.Fatalf("want the zin-repeat as second node, got %s", dump(nodes))
+	}
+	want := "Missing closing </zin-repeat> tag for the tag opened at line 2, column 3."
+	if el.Err != want {
+		t.Errorf("Err = %q, want %q", el.Err, want)
+	}
+	if expr := nodes[2].(*ExprNode); expr.Line != 2 || expr.Col != 23 {
+		t.Errorf("expression at %d:%d, want 2:23", expr.Line, expr.Col)
+	}
+}
+
+func TestParseEscapeContext(t *testing.T) {
+	tests := []struct {
+		src  string
+		want escapeContext
+	}{
+		{`<p>{{ x }}</p>`, escapeContext{kind: contextText}},
+		{`<textarea>{{ x }}</textarea>`, escapeContext{kind: contextText}},
+		{`<!-- <script> -->{{ x }}`, escapeContext{kind: contextText}},
+		{`<script>a</script>{{ x }}`, escapeContext{kind: contextText}},
+		{`<p {{ x }}>`, escapeContext{kind: contextText, attr: true}},
+
+		{`<a title="{{ x }}">`, escapeContext{kind: contextAttr, attr: true, quote: '"'}},
+		{`<a title='{{ x }}'>`, escapeContext{kind: contextAttr, attr: true, quote: '\''}},
+		{`<a title={{ x }}>`, escapeContext{kind: contextAttr, attr: true}},
+
+		{`<a href="{{ x }}">`, escapeContext{kind: contextURL, attr: true, quote: '"', urlStart: true}},
+		{`<a HREF='{{ x }}#top'>`, escapeContext{kind: contextURL, attr: true, quote: '\'', urlStart: true}},
+		{`<a href="/s?q={{ x }}">`, escapeContext{kind: contextURL, attr: true, quote: '"', urlQuery: true}},
+
+		{`<script>var a = {{ x }};</script>`, escapeContext{kind: contextJS}},
+		{`<SCRIPT>{{ x }}</SCRIPT>`, escapeContext{kind: contextJS}},
+		{`<script>var a = "{{ x }}";</script>`, escapeContext{kind: contextJS, jsQuote: '"'}},
+		{`<script>var a = 'it\'s {{ x }}';</script>`, escapeContext{kind: contextJS, jsQuote: '\''}},
+		{`<zin-if cond="1"><script>{{ x }}</script></zin-if>`, escapeContext{kind: contextJS}},
+		{`<button onclick="go({{ x }})">`, escapeContext{kind: contextJS, attr: true, quote: '"'}},
+		{`<img src=x onerror="{{ x }}">`, escapeContext{kind: contextJS, attr: true, quote: '"'}},
+
+		{`<style>p { color: {{ x }} }</style>`, escapeContext{kind: contextCSS}},
+		{`<p style="color: {{ x }}">`, escapeContext{kind: contextCSS, attr: true, quote: '"'}},
+	}
+
+	for _, tt := range tests {
+		var found *ExprNode
+		var find func([]Node)
+		find = func(nodes []Node) {
+			for _, n := range nodes {
+				switch v := n.(type) {
+				case *ExprNode:
+					found = v
+				case *ElementNode:
+					find(v.Children)
+				}
+			}
+		}
+		find(Parse(tt.src))
+
+		if found == nil {
+			t.Errorf("Parse(%s) has no expression", tt.src)
+			continue
+		}
+		if found.Context != tt.want {
+			t.Errorf("Parse(%s) context = %+v, want %+v", tt.src, found.Context, tt.want)
+		}
+	}
+}
@@ -2,29 +2,32 @@ package directives
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
 	"zin-engine/model"
 	"zin-engine/utils"
 )
 
-func HighlightUnsupportedTags(content string, ctx *model.RequestContext) string {
-
-	// No incorrect zin-tags, return unchanged
-	if !strings.Contains(content, "<zin") {
-		return content
-	}
+// HighlightUnsupportedTags hands every zin tag left in the page to the external modules
+func HighlightUnsupportedTags(nodes []Node, ctx *model.RequestContext) []Node {
+	out := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		el, ok := node.(*ElementNode)
+		if !ok {
+			out = append(out, node)
+			continue
+		}
 
-	rg := regexp.MustCompile(`<zin\b[^>]*\/?>`)
-	matches := rg.FindAllString(content, -1)
-	for _, tag := range matches {
+		tag := el.Raw
 		replace, err := utils.RunExternalModules(ctx.Root, tag)
 		if err != nil {
-			replace = SetInlineError(fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Oops! %v.", err))
+			replace = SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", tag), fmt.Sprintf("Oops! %v.", err))
 		}
 
-		content = strings.Replace(content, tag, replace, 1)
+		// Children of an unknown block are kept as they are
+		out = append(out, textNodes(replace)...)
+		out = append(out, HighlightUnsupportedTags(el.Children, ctx)...)
+		if el.CloseRaw != "" {
+			out = append(out, &TextNode{Pos: el.Pos, Text: el.CloseRaw})
+		}
 	}
-
-	return content
+	return out
 }
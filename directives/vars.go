@@ -2,95 +2,112 @@ package directives
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 	"zin-engine/model"
-	"zin-engine/utils"
 )
 
-func SetVarDirectives(content string, ctx *model.RequestContext) string {
-	// No set directive, return unchanged
-	if !strings.Contains(content, "<zin-set") {
-		return content
-	}
-
-	// Regular expression to find all <zin-set ... /> tags
-	re := regexp.MustCompile(`<zin-set\s+[^>]*\/>`)
-
-	// Find all matches
-	matches := re.FindAllString(content, -1)
-
-	for _, match := range matches {
-		// Extract key and value using regex
-		keyRe := regexp.MustCompile(`key="(.*?)"`)
-		valRe := regexp.MustCompile(`value="(.*?)"`)
-
-		keyMatch := keyRe.FindStringSubmatch(match)
-		valMatch := valRe.FindStringSubmatch(match)
-
-		if keyMatch == nil || valMatch == nil {
-			// Missing key or value
-			reason := SetInlineError(fmt.Sprintf("Failed to load: %s", match), "missing key or value attribute")
-			content = strings.Replace(content, match, reason, 1)
-			continue
-		}
+func setVarDirective(el *ElementNode, ctx *model.RequestContext) []Node {
+	key, hasKey := el.Attr("key")
+	val, hasVal := el.Attr("value")
 
-		key := keyMatch[1]
-		val := valMatch[1]
+	if !hasKey || !hasVal {
+		// Missing key or value
+		return inlineError(ctx, fmt.Sprintf("Failed to load: %s", el.Raw), "missing key or value attribute")
+	}
 
-		// Save to context
-		if ctx.LocalVar == nil {
-			ctx.LocalVar = make(map[string]string)
-		}
-		ctx.LocalVar[key] = val
+	// Evaluated top to bottom, a value can use anything defined before it
+	if ctx.DocumentOrder {
+		val = el.replaceVariables(val, ctx)
+	}
 
-		// Remove tag from content (or replace with empty string)
-		content = strings.Replace(content, match, "", 1)
+	// Save to context
+	if ctx.LocalVar == nil {
+		ctx.LocalVar = make(map[string]string)
 	}
+	ctx.LocalVar[key] = val
 
-	return content
+	// Remove tag from content
+	return nil
 }
 
 // Replace all vars with actual value
-// Match patterns like {{key}}, {{key.sub-key}}, {{key || "apple"}}
+// Match patterns like {{key}}, {{key.sub-key}}, {{key || "apple"}}, {{ title | upper }}
 func ReplaceVariables(content string, ctx *model.RequestContext) string {
+	return replaceScopeVariables(content, newScope(ctx))
+}
 
-	// No vars? No drama. Just return it like a boss.
-	if !strings.Contains(content, "{{") {
-		return content
+func replaceScopeVariables(content string, scope *Scope) string {
+	return replaceExpressions(content, func(expr string, raw string) string {
+		return resolveVariable(scope, expr)
+	})
+}
+
+// ReplaceExpressionNodes resolves every {{ }} left in the page, including attributes of remaining zin tags
+func ReplaceExpressionNodes(nodes []Node, ctx *model.RequestContext) []Node {
+	for i, node := range nodes {
+		switch n := node.(type) {
+		case *ExprNode:
+			nodes[i] = expressionNode(ctx, n)
+		case *ElementNode:
+			n.mapStrings(func(s string) string { return n.replaceVariables(s, ctx) })
+			n.Children = ReplaceExpressionNodes(n.Children, ctx)
+		}
 	}
+	return nodes
+}
 
-	re := regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+// expressionNode renders a {{ }} placed in the page, escaped for where it is printed.
+// A broken expression shows an inline error.
+func expressionNode(ctx *model.RequestContext, n *ExprNode) Node {
+	val, err := resolveValue(newScope(ctx), n.Expr)
+	if err != nil {
+		return &TextNode{Pos: n.Pos, Text: SetInlineError(ctx, fmt.Sprintf("Failed To Load: %s", n.Raw), fmt.Sprintf("Invalid expression at line %d, column %d: %v", n.Line, n.Col, err))}
+	}
+	return &TextNode{Pos: n.Pos, Text: escapeValue(n.Context, val)}
+}
 
-	matches := re.FindAllStringSubmatch(content, -1)
+// resolveVariable evaluates the inside of {{ }} e.g. key || "apple" or price * qty | round:2.
+// The value is not escaped, directives use it as is. A broken expression gives "undefined", as a missing key does.
+func resolveVariable(scope *Scope, expr string) string {
+	val, err := resolveValue(scope, expr)
+	if err != nil {
+		return "undefined"
+	}
+	return valueText(val)
+}
 
-	for _, match := range matches {
-		fullMatch := match[0]               // e.g., {{key || "apple"}}
-		expr := strings.TrimSpace(match[1]) // inside of {{...}}, e.g., key || "apple"
+// resolveValue evaluates an expression, a missing key in the plain legacy forms gives their default
+func resolveValue(scope *Scope, expr string) (any, error) {
+	key, defaultVal, ok := legacyExpression(expr)
+	if !ok {
+		return evalExpression(expr, scope)
+	}
 
-		// Split by ||
-		parts := strings.SplitN(expr, "||", 2)
+	// Get the value, typed so JSON & LIST values print properly in scripts
+	if val, found := scope.Lookup(key); found {
+		return val, nil
+	}
+	return defaultVal, nil
+}
 
-		key := strings.TrimSpace(parts[0])
-		defaultVal := "undefined"
+// legacyExpression matches the plain `key` and `key || "default"` forms, they keep their original
+// meaning: a missing key prints "undefined" and the default is always text, quoted or not
+func legacyExpression(expr string) (string, string, bool) {
+	parts := strings.SplitN(expr, "||", 2)
+	key := strings.TrimSpace(parts[0])
+	defaultVal := "undefined"
 
-		if len(parts) == 2 {
-			// Anything after || is treated as a string
-			defaultVal = strings.ReplaceAll(strings.TrimSpace(parts[1]), `"`, "")
-		}
+	if tokens, err := lexExpr(key); err != nil || len(tokens) != 2 || tokens[0].kind != tokIdent {
+		return "", "", false
+	}
 
-		// Determine if it's an env variable
-		isEnv := strings.HasPrefix(key, "process.env.")
-		if isEnv {
-			key = strings.ToUpper(strings.ReplaceAll(key, "process.env.", ""))
+	if len(parts) == 2 {
+		// Anything after || is treated as a string
+		def := strings.TrimSpace(parts[1])
+		if tokens, err := lexExpr(def); err != nil || len(tokens) > 2 || len(tokens) == 2 && tokens[0].kind == tokOp {
+			return "", "", false
 		}
-
-		// Get the value
-		resolved := utils.GetValue(ctx, key, defaultVal, isEnv)
-
-		// Replace the match in content
-		content = strings.Replace(content, fullMatch, resolved, 1)
+		defaultVal = strings.ReplaceAll(def, `"`, "")
 	}
-
-	return content
+	return key, defaultVal, true
 }
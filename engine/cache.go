@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"container/list"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bound of rendered routes & composed pages kept in memory
+const (
+	maxRouteCacheEntries = 1024
+	maxPageCacheEntries  = 512
+)
+
+// composedPage is the template + include output of a page and the files it was built from
+type composedPage struct {
+	key     string
+	content string
+	title   string // title of the zin-page tags, the page path is the fallback
+	files   map[string]time.Time
+}
+
+// PageCache keeps composed pages until one of their files changes on disk,
+// the least recently used page is dropped when full
+type PageCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// RouteCache keeps final rendered output of opted-in routes for a fixed time.
+// A route is cached per value of the query params its page reads, vary lists them by route.
+type RouteCache struct {
+	mu      sync.Mutex
+	entries map[string]routeCacheEntry
+	vary    map[string][]string
+}
+
+type routeCacheEntry struct {
+	content string
+	expires time.Time
+}
+
+var (
+	pageCache  = &PageCache{entries: make(map[string]*list.Element), order: list.New()}
+	routeCache = &RouteCache{entries: make(map[string]routeCacheEntry), vary: make(map[string][]string)}
+)
+
+// fileModTime returns a zero time for files that don't exist (yet)
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// snapshotFiles records the current modification time of every dependency
+func snapshotFiles(paths []string) map[string]time.Time {
+	files := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		files[path] = fileModTime(path)
+	}
+	return files
+}
+
+func (pc *PageCache) Get(key string) (*composedPage, bool) {
+	pc.mu.Lock()
+	elem, ok := pc.entries[key]
+	if ok {
+		pc.order.MoveToFront(elem)
+	}
+	pc.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	// Any created, changed or removed file invalidates the entry
+	entry := elem.Value.(*composedPage)
+	for path, modTime := range entry.files {
+		if !fileModTime(path).Equal(modTime) {
+			pc.mu.Lock()
+			if pc.entries[key] == elem {
+				pc.order.Remove(elem)
+				delete(pc.entries, key)
+			}
+			pc.mu.Unlock()
+			return nil, false
+		}
+	}
+
+	return entry, true
+}
+
+func (pc *PageCache) Set(entry *composedPage) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if elem, ok := pc.entries[entry.key]; ok {
+		elem.Value = entry
+		pc.order.MoveToFront(elem)
+		return
+	}
+	pc.entries[entry.key] = pc.order.PushFront(entry)
+
+	for pc.order.Len() > maxPageCacheEntries {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*composedPage).key)
+	}
+}
+
+// Get looks up route for the current query, only the params its page read make a difference
+func (rc *RouteCache) Get(route string, query url.Values) (string, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	params, ok := rc.vary[route]
+	if !ok {
+		return "", false
+	}
+	key := routeKey(route, params, query)
+
+	entry, ok := rc.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(rc.entries, key)
+		return "", false
+	}
+
+	return entry.content, true
+}
+
+// Set stores the output of route, reads are the query params the page looked up while rendering
+func (rc *RouteCache) Set(route string, query url.Values, reads map[string]bool, content string, ttl time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	// Drop expired entries before growing, skip caching if still full
+	if len(rc.entries) >= maxRouteCacheEntries || len(rc.vary) >= maxRouteCacheEntries {
+		now := time.Now()
+		for k, entry := range rc.entries {
+			if now.After(entry.expires) {
+				delete(rc.entries, k)
+			}
+		}
+		if len(rc.entries) == 0 {
+			clear(rc.vary)
+		}
+		if len(rc.entries) >= maxRouteCacheEntries || len(rc.vary) >= maxRouteCacheEntries {
+			return
+		}
+	}
+
+	// Params only grow, a render that reads fewer of them never makes others share its output
+	params := varyParams(rc.vary[route], reads)
+	rc.vary[route] = params
+	rc.entries[routeKey(route, params, query)] = routeCacheEntry{content: content, expires: time.Now().Add(ttl)}
+}
+
+// varyParams merges the params read into the known ones, sorted, "*" alone stands for the whole query
+func varyParams(known []string, reads map[string]bool) []string {
+	set := make(map[string]bool, len(known)+len(reads))
+	for _, p := range known {
+		set[p] = true
+	}
+	for p := range reads {
+		set[p] = true
+	}
+	if set["*"] {
+		return []string{"*"}
+	}
+
+	params := make([]string, 0, len(set))
+	for p := range set {
+		params = append(params, p)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// routeKey is the route with the values of the given params, e.g. root|/blog?page=2&tag=
+func routeKey(route string, params []string, query url.Values) string {
+	if len(params) == 1 && params[0] == "*" {
+		return route + "?" + query.Encode()
+	}
+
+	var b strings.Builder
+	b.WriteString(route)
+	for i, p := range params {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(p))
+		for j, v := range query[p] {
+			if j == 0 {
+				b.WriteByte('=')
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(url.QueryEscape(v))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeSite(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func get(t *testing.T, root string, target string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	HandleRequest(rec, httptest.NewRequest("GET", target, nil), root, "zin/test")
+	if rec.Code != 200 {
+		t.Fatalf("GET %s: status %d, %s", target, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func TestPageCacheKeyedByURIAndFile(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{
+		"zin.config":    `<zin-rewrite path="/a" to="post.html" />` + "\n" + `<zin-rewrite path="/b" to="post.html" />`,
+		"template.html": "<html><head></head><body>{{.children}}</body></html>",
+		"post.html":     "<p>post</p>",
+	})
+
+	a, b := get(t, root, "/a"), get(t, root, "/b")
+	if !strings.Contains(a, "<title>/a</title>") || !strings.Contains(b, "<title>/b</title>") {
+		t.Errorf("the fallback title should be the requested path, got %q and %q", a, b)
+	}
+
+	pageCache.mu.Lock()
+	defer pageCache.mu.Unlock()
+	entries := 0
+	for key := range pageCache.entries {
+		if strings.HasPrefix(key, root+"|") {
+			entries++
+		}
+	}
+	if entries != 2 {
+		t.Errorf("templates depend on the URL, two URLs of one file need their own cached page, got %d entries", entries)
+	}
+}
+
+func TestTemplatesFollowRequestURI(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{
+		"zin.config":            `<zin-rewrite path="/product/123" to="product.html" />`,
+		"template.html":         "<html><head></head><body>{{.children}}</body></html>",
+		"product/template.html": "<main>{{.children}}</main>",
+		"blog/template.html":    "<article>{{.children}}</article>",
+		"product.html":          "<p>product</p>",
+		"blog.html":             "<p>blog</p>",
+	})
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/product/123", "<body><main><p>product</p></main></body>"},
+		{"/blog", "<body><article><p>blog</p></article></body>"},
+		{"/product.html", "<body><p>product</p></body>"},
+	}
+	for _, tt := range tests {
+		if got := get(t, root, tt.target); !strings.Contains(got, tt.want) {
+			t.Errorf("GET %s = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPageCacheDropsLeastRecentlyUsed(t *testing.T) {
+	pc := &PageCache{entries: make(map[string]*list.Element), order: list.New()}
+
+	for i := 0; i < maxPageCacheEntries; i++ {
+		pc.Set(&composedPage{key: fmt.Sprint(i), content: fmt.Sprint(i)})
+	}
+	if _, ok := pc.Get("0"); !ok {
+		t.Fatal("page 0 should be cached")
+	}
+	pc.Set(&composedPage{key: "new"})
+
+	if _, ok := pc.Get("0"); !ok {
+		t.Error("page 0 was used last, it should have been kept")
+	}
+	if _, ok := pc.Get("1"); ok {
+		t.Error("page 1 was the least recently used, it should have been dropped")
+	}
+	if pc.order.Len() != maxPageCacheEntries || len(pc.entries) != maxPageCacheEntries {
+		t.Errorf("cache holds %d pages, limit is %d", len(pc.entries), maxPageCacheEntries)
+	}
+}
+
+func TestRouteCacheVariesOnReadParams(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{
+		"zin.config": `<zin-cache path="/list" ttl="1m" />`,
+		"list.html":  "<p>v1 {{ tag }}</p>",
+	})
+
+	if got := get(t, root, "/list?tag=go&utm=mail"); !strings.Contains(got, "v1 go") {
+		t.Fatalf("first render = %q", got)
+	}
+
+	// Cached output is kept for the TTL even when the file changes, that tells hits from renders
+	writeSite(t, root, map[string]string{"list.html": "<p>v2 {{ tag }}</p>"})
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/list?tag=go&utm=ads", "v1 go"},
+		{"/list?utm=x&tag=go", "v1 go"},
+		{"/list?tag=rust", "v2 rust"},
+		{"/list?tag=go&tag=rust", "v2 go"},
+		{"/list", "v2 undefined"},
+	}
+	for _, tt := range tests {
+		if got := get(t, root, tt.target); !strings.Contains(got, tt.want) {
+			t.Errorf("GET %s = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	query := map[string][]string{"b": {"1", "2"}, "a": {""}, "c": {"x&y"}}
+	tests := []struct {
+		params []string
+		want   string
+	}{
+		{nil, "r"},
+		{[]string{"a", "b", "missing"}, "r?a=&b=1,2&missing"},
+		{[]string{"c"}, "r?c=x%26y"},
+		{[]string{"*"}, "r?a=&b=1&b=2&c=x%26y"},
+	}
+	for _, tt := range tests {
+		if got := routeKey("r", tt.params, query); got != tt.want {
+			t.Errorf("routeKey(%v) = %q, want %q", tt.params, got, tt.want)
+		}
+	}
+
+	rc := &RouteCache{entries: make(map[string]routeCacheEntry), vary: make(map[string][]string)}
+	rc.Set("r", query, map[string]bool{"a": true}, "one", time.Minute)
+	rc.Set("r", query, map[string]bool{"c": true}, "two", time.Minute)
+	if got := rc.vary["r"]; strings.Join(got, ",") != "a,c" {
+		t.Errorf("params read by each render should add up, got %v", got)
+	}
+}
+
+func TestRouteCacheSkipsPerVisitorPages(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer api.Close()
+
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{
+		".env":         "FORM_SECRET=0123456789abcdef0123456789abcdef\nOUTBOUND_ALLOW_PRIVATE=ON",
+		"zin.config":   `<zin-cache path="/contact" ttl="1m" />` + "\n" + `<zin-cache path="/lucky" ttl="1m" />`,
+		"contact.html": `<zin-form action="` + api.URL + `"><input name="name"></zin-form>`,
+		"lucky.html":   `<p><zin-random type="NUMBER" length="12" /></p>`,
+	})
+
+	formRegex := regexp.MustCompile(`id="([^"]+)" data-source="[^"]*" data-session="([^"]+)"`)
+	var forms [][]string
+	for i := 0; i < 2; i++ {
+		m := formRegex.FindStringSubmatch(get(t, root, "/contact"))
+		if m == nil {
+			t.Fatal("the page has no form")
+		}
+		forms = append(forms, m)
+	}
+	if forms[0][2] == forms[1][2] {
+		t.Fatal("two visitors got the same cached form token")
+	}
+
+	for i, m := range forms {
+		body, _ := json.Marshal(map[string]string{"zinFormId": m[1], "zinFormSession": m[2], "zinFormSource": "contact", "name": "Ann"})
+		rec := httptest.NewRecorder()
+		HandleRequest(rec, httptest.NewRequest("POST", "/zin-form", strings.NewReader(string(body))), root, "zin/test")
+		if rec.Code != 200 {
+			t.Errorf("visitor %d submitting the form: status %d, %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	if a, b := get(t, root, "/lucky"), get(t, root, "/lucky"); a == b {
+		t.Errorf("zin-random output was served from the cache: %q", a)
+	}
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"zin-engine/model"
+	"zin-engine/utils"
+)
+
+const defaultCORSMaxAge = "600"
+
+// allowedMethods lists what the router answers for a given path
+func allowedMethods(path string) string {
+	if strings.HasPrefix(path, "/zin-form") || strings.HasPrefix(path, cachePurgePath) {
+		return "POST, OPTIONS"
+	}
+	return "GET, HEAD, OPTIONS"
+}
+
+// setCORSHeaders adds Access-Control-* headers when the request origin is allowed in .env
+func setCORSHeaders(w http.ResponseWriter, ctx *model.RequestContext) bool {
+	origin := ctx.Headers["Origin"]
+	allowed := utils.GetEnvValue(ctx, "CORS_ALLOW_ORIGIN", "")
+	if origin == "" || allowed == "" {
+		return false
+	}
+
+	candidates := strings.Split(allowed, ",")
+	for i := range candidates {
+		candidates[i] = strings.TrimSpace(candidates[i])
+	}
+
+	// Credentials are only shared with origins listed by name, a wildcard would hand them to any site
+	credentials := utils.GetEnvValue(ctx, "CORS_ALLOW_CREDENTIALS", "false") == "true"
+	if credentials && slices.Contains(candidates, "*") {
+		fmt.Printf(">> CORS Error: CORS_ALLOW_CREDENTIALS can't be used with * in CORS_ALLOW_ORIGIN, credentials are not allowed\n")
+		credentials = false
+	}
+
+	matched := ""
+	for _, candidate := range candidates {
+		if candidate == "*" {
+			matched = "*"
+			break
+		}
+		if strings.EqualFold(candidate, origin) {
+			matched = origin
+			break
+		}
+	}
+
+	if matched == "" {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", matched)
+	if matched != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	if credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+// HandleOptions answers OPTIONS requests including CORS preflights
+func HandleOptions(w http.ResponseWriter, ctx *model.RequestContext) {
+	allow := allowedMethods(ctx.Path)
+	w.Header().Set("Allow", allow)
+	w.Header().Set("Parser", ctx.ServerVersion)
+
+	// Preflight details are only sent to allowed origins
+	if setCORSHeaders(w, ctx) && ctx.Headers["Access-Control-Request-Method"] != "" {
+		w.Header().Set("Access-Control-Allow-Methods", utils.GetEnvValue(ctx, "CORS_ALLOW_METHODS", allow))
+		headers := utils.GetEnvValue(ctx, "CORS_ALLOW_HEADERS", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+		w.Header().Set("Access-Control-Max-Age", utils.GetEnvValue(ctx, "CORS_MAX_AGE", defaultCORSMaxAge))
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"net/http/httptest"
+	"testing"
+	"zin-engine/model"
+)
+
+func TestCORSHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     string
+		credentials string
+		origin      string
+		wantOrigin  string
+		wantCreds   bool
+	}{
+		{"wildcard", "*", "false", "https://evil.test", "*", false},
+		{"wildcard never carries credentials", "*", "true", "https://evil.test", "*", false},
+		{"wildcard in a list never carries credentials", "https://app.test, *", "true", "https://app.test", "https://app.test", false},
+		{"listed origin with credentials", "https://app.test", "true", "https://APP.test", "https://APP.test", true},
+		{"listed origin", "https://app.test, https://admin.test", "false", "https://admin.test", "https://admin.test", false},
+		{"unlisted origin", "https://app.test", "true", "https://evil.test", "", false},
+		{"no origin configured", "", "true", "https://app.test", "", false},
+	}
+
+	for _, tt := range tests {
+		ctx := &model.RequestContext{
+			Headers: map[string]string{"Origin": tt.origin},
+			ENV:     map[string]string{"CORS_ALLOW_ORIGIN": tt.allowed, "CORS_ALLOW_CREDENTIALS": tt.credentials},
+		}
+		rec := httptest.NewRecorder()
+		setCORSHeaders(rec, ctx)
+
+		gotOrigin := rec.Header().Get("Access-Control-Allow-Origin")
+		if tt.wantOrigin != "" && gotOrigin != tt.wantOrigin {
+			t.Errorf("%s: Allow-Origin = %q, want %q", tt.name, gotOrigin, tt.wantOrigin)
+		}
+		if gotOrigin != "*" && gotOrigin != "" && gotOrigin != tt.origin {
+			t.Errorf("%s: Allow-Origin = %q reflects something else than the request origin", tt.name, gotOrigin)
+		}
+		if creds := rec.Header().Get("Access-Control-Allow-Credentials") == "true"; creds != tt.wantCreds {
+			t.Errorf("%s: Allow-Credentials sent = %v, want %v", tt.name, creds, tt.wantCreds)
+		}
+	}
+}
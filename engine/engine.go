@@ -1,12 +1,9 @@
 package engine
 
 import (
-	"bufio"
-	"context"
 	"fmt"
 	"net"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"zin-engine/config"
 	"zin-engine/controller"
@@ -15,35 +12,32 @@ import (
 	"zin-engine/utils"
 )
 
-var (
-	rootDir    string
-	zinVersion string
-)
-
-func HandleConnection(ctx context.Context, conn net.Conn, root string, version string) {
-	defer conn.Close()
+func HandleRequest(w http.ResponseWriter, req *http.Request, root string, version string) {
 
-	zinVersion = version
-	reader := bufio.NewReader(conn)
-	req, err := http.ReadRequest(reader)
-	if err != nil {
-		PrintErrorOnClient(conn, 500, "<untracked>", fmt.Sprintf("Invalid request: %v", err))
-		return
-	}
+	// Minimal context used to report errors until the session context is composed
+	errCtx := &model.RequestContext{ServerVersion: version}
 
 	// Log Request
-	fmt.Printf("\n----->>>> HTTP/1.1 %s [%s] %s\n", req.Method, conn.RemoteAddr().String(), req.URL.Path)
+	fmt.Printf("\n----->>>> %s %s [%s] %s\n", req.Proto, req.Method, req.RemoteAddr, req.URL.Path)
 	fmt.Printf(">> Host: %s\n", req.Host)
 
 	// Handle Request
 	select {
-	case <-ctx.Done():
+	case <-req.Context().Done():
 		// Graceful exit if timeout occurs
-		ConnTimeOut(conn)
+		ConnTimeOut(w, errCtx)
 		return
 	default:
-		// Read the X-Root-Dir header
-		rootDir = req.Header.Get("X-Root-Dir")
+		// Read the X-Root-Dir header, only honoured when set by a trusted proxy
+		rootDir, fromHeader, err := headerRoot(req, root)
+		if err != nil {
+			fmt.Printf(">> Refused X-Root-Dir from %s: %v\n", req.RemoteAddr, err)
+			PrintErrorOnClient(w, errCtx, 403, req.URL.Path, "Forbidden — "+err.Error())
+			return
+		}
+		if fromHeader {
+			fmt.Printf(">> Root: %s\n", rootDir)
+		}
 
 		// Reset rootDir from configured directory
 		if rootDir == "" && root != "" {
@@ -52,35 +46,57 @@ func HandleConnection(ctx context.Context, conn net.Conn, root string, version s
 
 		// Handle other route when root-dir is not configured
 		if rootDir == "" {
-			PrintErrorOnClient(conn, 500, req.URL.Path, "Missing X-Root-Dir on nginx server configuration.")
+			PrintErrorOnClient(w, errCtx, 500, req.URL.Path, "Missing X-Root-Dir on nginx server configuration.")
 			return
 		}
+		errCtx.Root = rootDir
+
+		// Compose session content
+		ctx := ComposeSessionContext(req, rootDir, version)
 
-		// Get file to serve & check if listed in .zinignore
+		// Get file to serve, refuse it when outside of the root or listed in .zinignore
 		path := utils.GetFilePathFromURI(req.URL.Path)
-		if config.CheckZinIgnore(rootDir, path) {
-			PrintErrorOnClient(conn, 403, path, "Forbidden — You do not have permission to access this file")
+
+		// Engine files & _partials are never served, as if they weren't there
+		if config.IsInternalPath(path) {
+			PrintErrorOnClient(w, &ctx, 404, req.URL.Path, fmt.Sprintf("Error: Unable to find file at `%s`.", req.URL.Path))
+			return
+		}
+		if ctx.ContentSource == "" || config.CheckZinIgnore(rootDir, path) {
+			PrintErrorOnClient(w, errCtx, 403, path, "Forbidden — You do not have permission to access this file")
 			return
 		}
 
-		// Compose session content
-		ctx := ComposeSessionContext(conn, req)
+		// Answer preflights & capability checks, CORS headers apply to every response
+		if req.Method == http.MethodOptions {
+			HandleOptions(w, &ctx)
+			return
+		}
+		setCORSHeaders(w, &ctx)
 
 		// Handle form submission
 		if req.Method == http.MethodPost && strings.HasPrefix(path, "/zin-form") {
-			statusCode, content := controller.HandleFormSubmission(req, &ctx)
-			JsonResponse(conn, statusCode, content)
+			statusCode, content := controller.HandleFormSubmission(w, req, &ctx)
+			JsonResponse(w, &ctx, statusCode, content)
+			return
+		}
+
+		// Handle cache purge
+		if req.Method == http.MethodPost && strings.HasPrefix(path, cachePurgePath) {
+			statusCode, content := HandleCachePurge(req, &ctx)
+			JsonResponse(w, &ctx, statusCode, content)
 			return
 		}
 
-		// Only req.methods type:GET is allowed
-		if req.Method != http.MethodGet {
-			PrintErrorOnClient(conn, 405, req.URL.Path, "")
+		// Only req.methods type:GET & HEAD are allowed, HEAD runs the same pipeline and the server drops the body
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", allowedMethods(path))
+			PrintErrorOnClient(w, &ctx, 405, req.URL.Path, "")
 			return
 		}
 
 		// Handle zin-default paths
-		if HandleDefaultLoads(conn, req, &ctx) {
+		if HandleDefaultLoads(w, req, &ctx) {
 			return
 		}
 
@@ -89,36 +105,38 @@ func HandleConnection(ctx context.Context, conn net.Conn, root string, version s
 
 		// If mine-type is not text/html just return the content as it is
 		if !strings.HasPrefix(ctx.ContentType, "text/html") {
-			SendRawFile(conn, &ctx)
+			SendRawFile(w, &ctx)
 			return
 		}
 
 		// Let's handle source file rendering along with re-write checks
-		HandleSourceRender(conn, req, &ctx)
+		HandleSourceRender(w, req, &ctx)
 
 	}
 }
 
-func getClientIP(conn net.Conn) string {
-	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+func getClientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
-		return conn.RemoteAddr().String()
+		return req.RemoteAddr
 	}
 	return ip
 }
 
-func ComposeSessionContext(conn net.Conn, req *http.Request) model.RequestContext {
+func ComposeSessionContext(req *http.Request, rootDir string, version string) model.RequestContext {
 	ctx := model.RequestContext{
-		ClientIp:      getClientIP(conn),
+		Context:       req.Context(),
+		ClientIp:      visitorIP(req),
 		Method:        req.Method,
 		Host:          req.Host,
 		Path:          req.URL.Path,
 		Root:          rootDir,
 		ContentType:   "text/plain",
 		ContentSource: req.URL.Path,
-		ServerVersion: zinVersion,
+		ServerVersion: version,
 		ServerError:   make(map[string]string),
 		Query:         req.URL.Query(),
+		QueryReads:    make(map[string]bool),
 		Headers:       make(map[string]string),
 		CustomVar: model.CustomVar{
 			Raw:  make(map[string]string),
@@ -138,47 +156,61 @@ func ComposeSessionContext(conn net.Conn, req *http.Request) model.RequestContex
 
 	// Set content source & type
 	path := utils.GetFilePathFromURI(req.URL.Path)
-	ctx.ContentSource = filepath.Join(rootDir, filepath.FromSlash(path))
+	ctx.ContentSource, _ = utils.ResolveSitePath(&ctx, path)
 	ctx.ContentType = utils.GetMineTypeFromPath(path)
 
 	// Check for gzip support
 	ctx.GzipCompression = strings.Contains(ctx.Headers["Accept-Encoding"], "gzip")
 
+	// Inline error mode is fixed for the whole request
+	ctx.InlineErrors = utils.GetValue(&ctx, "SHOW_ERRORS", "OFF", true) == "ON"
+
 	return ctx
 }
 
-func HandleDefaultLoads(conn net.Conn, req *http.Request, ctx *model.RequestContext) bool {
+func HandleDefaultLoads(w http.ResponseWriter, req *http.Request, ctx *model.RequestContext) bool {
 	// Favicon icon request
 	if req.URL.Path == "/favicon.ico" {
-		Favicon(conn, rootDir)
+		Favicon(w, ctx)
 		return true
 	}
 
 	if req.URL.Path == "/zin-assets/engine.css" {
 		ctx.Path = utils.GetExeAssetPath("engine.css")
-		SendRawFile(conn, ctx)
+		SendRawFile(w, ctx)
 		return true
 	}
 
 	if req.URL.Path == "/zin-assets/engine.js" {
 		ctx.Path = utils.GetExeAssetPath("engine.js")
-		SendRawFile(conn, ctx)
+		SendRawFile(w, ctx)
 		return true
 	}
 
 	return false
 }
 
-func HandleSourceRender(conn net.Conn, req *http.Request, ctx *model.RequestContext) {
+func HandleSourceRender(w http.ResponseWriter, req *http.Request, ctx *model.RequestContext) {
 
-	if HandleExistenceAndRedirect(conn, req, ctx) {
+	if HandleExistenceAndRedirect(w, req, ctx) {
 		return
 	}
 
+	// Serve opted-in routes from the output cache, see <zin-cache> in zin.config
+	ttl, cacheRoute := config.GetRouteCacheTTL(ctx.Root, req.URL.Path)
+	route := ctx.Root + "|" + req.URL.Path
+	if cacheRoute {
+		if content, ok := routeCache.Get(route, ctx.Query); ok {
+			fmt.Printf(">> Route Cache: HIT\n")
+			SendPageContent(w, content, ctx)
+			return
+		}
+	}
+
 	// Compose page content wrapped inside template.html - conditionally
-	content, err := GetPageContent(ctx.Root, req.URL.Path, ctx.ContentSource)
+	content, err := GetPageContent(ctx, req.URL.Path, ctx.ContentSource)
 	if err != nil {
-		PrintErrorOnClient(conn, 500, req.URL.Path, fmt.Sprintf("Template Parsing Error: %s", err.Error()))
+		PrintErrorOnClient(w, ctx, 500, req.URL.Path, fmt.Sprintf("Template Parsing Error: %s", err.Error()))
 		return
 	}
 
@@ -188,7 +220,7 @@ func HandleSourceRender(conn net.Conn, req *http.Request, ctx *model.RequestCont
 	// Check for errors during directive parsing
 	if len(ctx.ServerError) > 0 {
 		content := ComposeServerErrorContent(ctx)
-		PrintErrorOnClient(conn, 500, req.URL.Path, content)
+		PrintErrorOnClient(w, ctx, 500, req.URL.Path, content)
 		return
 	}
 
@@ -197,38 +229,45 @@ func HandleSourceRender(conn net.Conn, req *http.Request, ctx *model.RequestCont
 		content = utils.InjectZinScriptAndStyle(content)
 	}
 
+	// Pages with per-visitor output are rendered every time
+	if cacheRoute && ctx.Uncacheable {
+		fmt.Printf(">> Route Cache: SKIP, the page has zin-form, zin-random or zin-time output\n")
+	} else if cacheRoute {
+		routeCache.Set(route, ctx.Query, ctx.QueryReads, content, ttl)
+	}
+
 	// Finally Load Page Content
-	SendPageContent(conn, content, ctx)
+	SendPageContent(w, content, ctx)
 }
 
-func HandleExistenceAndRedirect(conn net.Conn, req *http.Request, ctx *model.RequestContext) bool {
+func HandleExistenceAndRedirect(w http.ResponseWriter, req *http.Request, ctx *model.RequestContext) bool {
 	if !utils.FileExists(ctx.ContentSource) {
-		route, err := config.GetReWriteTarget(ctx.Root, req.URL.Path)
+		route, err := config.GetReWriteTarget(ctx, req.URL.Path)
 		if err != nil {
 			statusCode := 404
 			if req.URL.Path == "/" {
 				statusCode = 200
 			}
-			PrintErrorOnClient(conn, statusCode, req.URL.Path, fmt.Sprintf("Error: Unable to find file at `%s`.", req.URL.Path))
+			PrintErrorOnClient(w, ctx, statusCode, req.URL.Path, fmt.Sprintf("Error: Unable to find file at `%s`.", req.URL.Path))
 			return true
 		}
 
 		// Redirect if target is external HTTP/S URI
 		if route.Type == "external" {
-			Redirect(conn, 302, route.Path)
+			Redirect(w, ctx, 302, route.Path)
 			return true
 		}
 
 		// Check file existence for the one last time XD
 		if !utils.FileExists(route.Path) {
-			PrintErrorOnClient(conn, 404, req.URL.Path, fmt.Sprintf("Error: Unable to find file at `%s`.", req.URL.Path))
+			PrintErrorOnClient(w, ctx, 404, req.URL.Path, fmt.Sprintf("Error: Unable to find file at `%s`.", req.URL.Path))
 			return true
 		}
 
 		// If not a HTML file the render it as raw
 		routeMimeType := utils.GetMineTypeFromPath(route.Path)
 		if !strings.HasPrefix(routeMimeType, "text/html") {
-			SendRawFile(conn, ctx)
+			SendRawFile(w, ctx)
 			return true
 		}
 
@@ -254,3 +293,16 @@ func ComposeServerErrorContent(ctx *model.RequestContext) string {
 
 	return content
 }
+
+// HandleHTTPSRedirect sends plain http clients to the same URI on the https port
+func HandleHTTPSRedirect(w http.ResponseWriter, req *http.Request, httpsPort string, version string) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if httpsPort != "443" {
+		host = net.JoinHostPort(host, httpsPort)
+	}
+
+	Redirect(w, &model.RequestContext{ServerVersion: version}, http.StatusMovedPermanently, "https://"+host+req.URL.RequestURI())
+}
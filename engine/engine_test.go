@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// createSite writes a minimal site whose title, variables and error mode are unique to id
+func createSite(t *testing.T, id int) string {
+	t.Helper()
+	root := t.TempDir()
+
+	showErrors := "OFF"
+	if id%2 == 0 {
+		showErrors = "ON"
+	}
+
+	files := map[string]string{
+		".env":          fmt.Sprintf("SHOW_ERRORS=%s\n", showErrors),
+		"template.html": fmt.Sprintf("<zin-page name=\"Site %d\"/><html><head><title>x</title></head><body>{{.children}}</body></html>", id),
+		"index.html":    fmt.Sprintf("<zin-set key=\"site\" value=\"site-%d\"/><p>{{ site }}</p><zin-unknown-tag />", id),
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// TestConcurrentRenders renders many different sites at once, run it with -race
+func TestConcurrentRenders(t *testing.T) {
+	const sites = 8
+	const rounds = 25
+
+	roots := make([]string, sites)
+	for i := range roots {
+		roots[i] = createSite(t, i)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, sites*rounds)
+
+	for r := 0; r < rounds; r++ {
+		for i, root := range roots {
+			wg.Add(1)
+			go func(id int, root string) {
+				defer wg.Done()
+
+				req := httptest.NewRequest("GET", "/", nil)
+				rec := httptest.NewRecorder()
+				HandleRequest(rec, req, root, "zin/test")
+
+				body := rec.Body.String()
+				if rec.Code != 200 {
+					errs <- fmt.Errorf("site %d: unexpected status %d", id, rec.Code)
+					return
+				}
+				if !strings.Contains(body, fmt.Sprintf("<title>Site %d</title>", id)) {
+					errs <- fmt.Errorf("site %d: wrong title in %q", id, body)
+				}
+				if !strings.Contains(body, fmt.Sprintf("<p>site-%d</p>", id)) {
+					errs <- fmt.Errorf("site %d: wrong variable in %q", id, body)
+				}
+				if hasInline := strings.Contains(body, "inline-error"); hasInline != (id%2 == 0) {
+					errs <- fmt.Errorf("site %d: inline error shown=%v in %q", id, hasInline, body)
+				}
+				if got := rec.Header().Get("Parser"); got != "zin/test" {
+					errs <- fmt.Errorf("site %d: wrong parser header %q", id, got)
+				}
+			}(i, root)
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestInternalFilesAreNotServed(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSite(t, root, map[string]string{
+		".env":           "SECRET=hunter2\n",
+		"_part.html":     "<p>hunter2</p>",
+		"modules/x.js":   "var secret = 'hunter2'",
+		"Template.html":  "<html>hunter2 {{.children}}</html>",
+		"index.html":     "<p>home</p>",
+		"Notes_404.html": "<p>notes</p>",
+	})
+
+	for _, target := range []string{"/.env", "/%2Eenv", "/%2eENV", "/_part.html", "/%5Fpart.html", "/modules/x.js", "/%6Dodules/x.js", "/Template.html", "/blog/..%2F.env"} {
+		rec := httptest.NewRecorder()
+		HandleRequest(rec, httptest.NewRequest("GET", target, nil), root, "zin/test")
+		if rec.Code != 404 || strings.Contains(rec.Body.String(), "hunter2") {
+			t.Errorf("GET %s = %d %q, want a 404", target, rec.Code, rec.Body.String())
+		}
+	}
+
+	if got := get(t, root, "/Notes_404.html"); !strings.Contains(got, "notes") {
+		t.Errorf("a regular page was refused: %q", got)
+	}
+}
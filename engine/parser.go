@@ -2,30 +2,57 @@ package engine
 
 import (
 	"fmt"
+	"html"
 	"html/template"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"zin-engine/directives"
+	"zin-engine/model"
 	"zin-engine/utils"
 )
 
-var extractedTitle = ""
+// GetPageContent returns the page wrapped in its templates with zin-includes expanded.
+// Templates are found along the request URI, so the key holds both the URI and the content file.
+// The result is cached until the page, a template or an included file changes.
+func GetPageContent(ctx *model.RequestContext, uri string, path string) (string, error) {
+	key := fmt.Sprintf("%s|%s|%s|%t", ctx.Root, uri, path, ctx.InlineErrors)
+	if page, ok := pageCache.Get(key); ok {
+		fmt.Printf(">> Page Cache: HIT\n")
+		return InjectTitleFromZinPage(page.content, page.title, uri), nil
+	}
+
+	// Template candidates are tracked even if missing, creating one must invalidate the cache.
+	// So is .env, STRICT_SYMLINKS decides which includes may be read.
+	files := append([]string{path, filepath.Join(ctx.Root, ".env")}, templateCandidates(ctx.Root, uri)...)
+
+	content, title, err := composePageContent(ctx, uri, path)
+	if err != nil {
+		return "", err
+	}
+
+	content, included := directives.ApplyIncludes(content, ctx)
+	pageCache.Set(&composedPage{key: key, content: content, title: title, files: snapshotFiles(append(files, included...))})
 
-// ToDo: Cache pageContent to avoid template parsing on each request
-func GetPageContent(root string, uri string, path string) (string, error) {
+	return InjectTitleFromZinPage(content, title, uri), nil
+}
+
+// composePageContent wraps the page inside every applicable template.html along uri,
+// it returns the title given by <zin-page> tags
+func composePageContent(ctx *model.RequestContext, uri string, path string) (string, string, error) {
 	page, err := utils.GetFileContent(path)
 	if err != nil {
-		return "", fmt.Errorf("content file not found: %v", err)
+		return "", "", fmt.Errorf("content file not found: %v", err)
 	}
 
 	// Collect applicable templates from most specific to root
-	extractedTitle = ""
-	templates := collectTemplates(root, uri)
+	extractedTitle := ""
+	templates := collectTemplates(ctx.Root, uri)
 
 	// If no templates found, return raw content
 	if len(templates) == 0 {
-		return page, nil
+		return page, "", nil
 	}
 
 	// Loop though each template till <html>
@@ -36,18 +63,18 @@ func GetPageContent(root string, uri string, path string) (string, error) {
 
 		tplBytes, err := os.ReadFile(templates[i])
 		if err != nil {
-			return "", fmt.Errorf("template read error: %v", err)
+			return "", "", fmt.Errorf("template read error: %v", err)
 		}
 		tplContent := string(tplBytes)
 
 		// Cache & removed zin-page tags if present in template-content
-		tplContent = ExtractTileFormZinPageTag(tplContent)
+		tplContent, extractedTitle = ExtractTileFormZinPageTag(tplContent, extractedTitle)
 
 		// Check if this is the final wrapping template
 		if strings.Contains(strings.ToLower(tplContent), "<html") {
 			tpl, err := template.New("tpl").Parse(tplContent)
 			if err != nil {
-				return "", fmt.Errorf("template parse error: %v", err)
+				return "", "", fmt.Errorf("template parse error: %v", err)
 			}
 
 			var rendered strings.Builder
@@ -56,17 +83,17 @@ func GetPageContent(root string, uri string, path string) (string, error) {
 			})
 
 			if err != nil {
-				return "", fmt.Errorf("template execution error: %v", err)
+				return "", "", fmt.Errorf("template execution error: %v", err)
 			}
 
 			// Done - This is the final HTML wrapper
-			return InjectTitleFromZinPage(rendered.String(), uri), nil
+			return rendered.String(), extractedTitle, nil
 		}
 
 		// Embed and continue upward
 		tpl, err := template.New("tpl").Parse(tplContent)
 		if err != nil {
-			return "", fmt.Errorf("template parse error: %v", err)
+			return "", "", fmt.Errorf("template parse error: %v", err)
 		}
 
 		var rendered strings.Builder
@@ -74,59 +101,74 @@ func GetPageContent(root string, uri string, path string) (string, error) {
 			"children": template.HTML(page),
 		})
 		if err != nil {
-			return "", fmt.Errorf("template execution error: %v", err)
+			return "", "", fmt.Errorf("template execution error: %v", err)
 		}
 
 		page = rendered.String()
 	}
 
 	// Done
-	return InjectTitleFromZinPage(page, uri), nil
+	return page, extractedTitle, nil
 
 }
 
 // collectTemplates walks upward from requestPath to root and gathers existing template.html files.
 func collectTemplates(rootDir string, requestPath string) []string {
 	var templates []string
+	for _, templatePath := range templateCandidates(rootDir, requestPath) {
+		if _, err := os.Stat(templatePath); err == nil {
+			templates = append(templates, templatePath)
+		}
+	}
+	return templates
+}
+
+// templateCandidates lists every template.html location from requestPath up to root
+func templateCandidates(rootDir string, requestPath string) []string {
+	var candidates []string
 	pathParts := strings.Split(filepath.Clean(requestPath), string(os.PathSeparator))
 
 	for i := len(pathParts); i >= 0; i-- {
 		subPath := filepath.Join(pathParts[:i]...)
-		templatePath := filepath.Join(rootDir, subPath, "template.html")
-		if _, err := os.Stat(templatePath); err == nil {
-			templates = append(templates, templatePath)
+		candidate := filepath.Join(rootDir, subPath, "template.html")
+		if len(candidates) > 0 && candidates[len(candidates)-1] == candidate {
+			continue
 		}
+		candidates = append(candidates, candidate)
 	}
-	return templates
+	return candidates
 }
 
+var (
+	titleRegex   = regexp.MustCompile(`(?i)<title>(.*?)</title>`)
+	headRegex    = regexp.MustCompile(`(?i)<head[^>]*>`)
+	zinPageRegex = regexp.MustCompile(`<zin-page\s+name=["']([^"']+)["']\s*/?>`)
+)
+
 // Page title
-func InjectTitleFromZinPage(content string, path string) string {
+func InjectTitleFromZinPage(content string, extractedTitle string, path string) string {
 
 	fmt.Printf("\n>> Page Title: %s", extractedTitle)
 	// Fallback to <title>...</title>
 	if extractedTitle == "" {
-		reTitle := regexp.MustCompile(`(?i)<title>(.*?)</title>`)
-		match := reTitle.FindStringSubmatch(content)
+		match := titleRegex.FindStringSubmatch(content)
 		if len(match) >= 2 {
 			extractedTitle = match[1]
 		}
 	}
 
-	// If page-title is still blank then set current path as title
+	// If page-title is still blank then set current path as title, it comes from the URL
 	if extractedTitle == "" {
-		extractedTitle = path
+		extractedTitle = html.EscapeString(path)
 	}
 
 	// Replace or insert <title>
-	reTitleTag := regexp.MustCompile(`(?i)<title>.*?</title>`)
-	if reTitleTag.MatchString(content) {
-		// Replace existing title
-		content = reTitleTag.ReplaceAllString(content, "<title>"+extractedTitle+"</title>")
+	if titleRegex.MatchString(content) {
+		// Replace existing title, $ in the title is no group reference
+		content = titleRegex.ReplaceAllLiteralString(content, "<title>"+extractedTitle+"</title>")
 	} else {
 		// Insert <title> inside <head>
-		reHead := regexp.MustCompile(`(?i)<head[^>]*>`)
-		if loc := reHead.FindStringIndex(content); loc != nil {
+		if loc := headRegex.FindStringIndex(content); loc != nil {
 			// Insert title right after <head>
 			insertPos := loc[1]
 			content = content[:insertPos] + "\n<title>" + extractedTitle + "</title>" + content[insertPos:]
@@ -139,18 +181,17 @@ func InjectTitleFromZinPage(content string, path string) string {
 	return content
 }
 
-func ExtractTileFormZinPageTag(content string) string {
+func ExtractTileFormZinPageTag(content string, title string) (string, string) {
 	if !strings.Contains(content, "<zin-page") {
-		return content
+		return content, title
 	}
 
-	reZin := regexp.MustCompile(`<zin-page\s+name=["']([^"']+)["']\s*/?>`)
-	match := reZin.FindStringSubmatch(content)
+	match := zinPageRegex.FindStringSubmatch(content)
 
 	if len(match) >= 2 {
-		extractedTitle = match[1]
-		content = reZin.ReplaceAllString(content, "")
+		title = match[1]
+		content = zinPageRegex.ReplaceAllString(content, "")
 	}
 
-	return content
+	return content, title
 }
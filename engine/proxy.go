@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// proxySecretHeader carries the shared secret of a trusted proxy
+const proxySecretHeader = "X-Zin-Proxy-Secret"
+
+// RootTrust decides when the X-Root-Dir header of a request is honoured.
+// The header is only taken from a trusted proxy, one connecting from Proxies or sending Secret,
+// It must name one of Roots, or be the configured root when Roots is empty.
+type RootTrust struct {
+	Proxies []*net.IPNet
+	Secret  string
+	Roots   []string // directories or glob patterns such as /var/www/*
+}
+
+// DefaultTrustedProxies is loopback only, so a proxy on the same machine keeps working
+const DefaultTrustedProxies = "127.0.0.1,::1"
+
+var rootTrust = RootTrust{Proxies: mustParseProxies(DefaultTrustedProxies)}
+
+// SetRootTrust replaces the trust settings, call it before serving
+func SetRootTrust(trust RootTrust) {
+	rootTrust = trust
+}
+
+// ParseRootTrust builds the trust settings from comma separated proxy ranges & roots
+func ParseRootTrust(proxies string, secret string, roots string) (RootTrust, error) {
+	trust := RootTrust{Secret: secret}
+
+	nets, err := parseProxies(proxies)
+	if err != nil {
+		return trust, err
+	}
+	trust.Proxies = nets
+
+	for _, root := range strings.Split(roots, ",") {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		if !filepath.IsAbs(root) {
+			return trust, fmt.Errorf("allowed root '%s' must be an absolute path", root)
+		}
+		if _, err := filepath.Match(root, ""); err != nil {
+			return trust, fmt.Errorf("allowed root '%s' is not a valid pattern", root)
+		}
+		trust.Roots = append(trust.Roots, filepath.Clean(root))
+	}
+
+	return trust, nil
+}
+
+func parseProxies(proxies string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(proxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy '%s' is not an IP or CIDR range", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseProxies(proxies string) []*net.IPNet {
+	nets, err := parseProxies(proxies)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// headerRoot returns the root a request asks for with X-Root-Dir, or an error telling why it's refused
+func headerRoot(req *http.Request, configuredRoot string) (string, bool, error) {
+	rootDir := req.Header.Get("X-Root-Dir")
+	if rootDir == "" {
+		return "", false, nil
+	}
+
+	if !fromTrustedProxy(req) {
+		return "", true, fmt.Errorf("X-Root-Dir is only accepted from a trusted proxy")
+	}
+
+	if !filepath.IsAbs(rootDir) {
+		return "", true, fmt.Errorf("X-Root-Dir must be an absolute path")
+	}
+	rootDir = filepath.Clean(rootDir)
+
+	// Without allowed roots a trusted proxy may only repeat the configured root
+	if len(rootTrust.Roots) == 0 {
+		if configuredRoot != "" && rootDir == filepath.Clean(configuredRoot) {
+			return rootDir, true, nil
+		}
+		return "", true, fmt.Errorf("X-Root-Dir '%s' is not the configured root, set -allowed-roots to serve others", rootDir)
+	}
+	for _, allowed := range rootTrust.Roots {
+		if ok, _ := filepath.Match(allowed, rootDir); ok || allowed == rootDir {
+			return rootDir, true, nil
+		}
+	}
+	return "", true, fmt.Errorf("X-Root-Dir '%s' is not one of the allowed roots", rootDir)
+}
+
+func fromTrustedProxy(req *http.Request) bool {
+	if rootTrust.Secret != "" {
+		if sent := req.Header.Get(proxySecretHeader); sent != "" {
+			return subtle.ConstantTimeCompare([]byte(sent), []byte(rootTrust.Secret)) == 1
+		}
+	}
+
+	return isTrustedProxy(net.ParseIP(getClientIP(req)))
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range rootTrust.Proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// visitorIP is the address of the visitor. Behind a trusted proxy it is taken from X-Real-IP,
+// or the last X-Forwarded-For hop that isn't a trusted proxy; anyone else can't set it with a header.
+func visitorIP(req *http.Request) string {
+	ip := getClientIP(req)
+	if !fromTrustedProxy(req) {
+		return ip
+	}
+
+	if realIP := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	hops := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		parsed := net.ParseIP(hop)
+		if parsed == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(parsed) {
+			break
+		}
+	}
+	return ip
+}
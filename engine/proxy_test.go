@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderRoot(t *testing.T) {
+	defer func(trust RootTrust) { rootTrust = trust }(rootTrust)
+
+	tests := []struct {
+		name   string
+		roots  string
+		remote string
+		header string
+		want   string // root honoured, empty when refused
+	}{
+		{"loopback proxy, configured root", "", "127.0.0.1:4000", "/srv/site", "/srv/site"},
+		{"loopback proxy, other root", "", "127.0.0.1:4000", "/etc", ""},
+		{"loopback proxy, root not clean", "", "127.0.0.1:4000", "/srv/other/../site", "/srv/site"},
+		{"untrusted client", "", "203.0.113.7:4000", "/srv/site", ""},
+		{"rest of loopback is not trusted", "", "127.0.0.2:4000", "/srv/site", ""},
+		{"ipv6 loopback", "", "[::1]:4000", "/srv/site", "/srv/site"},
+		{"allowed pattern", "/var/www/*", "127.0.0.1:4000", "/var/www/blog", "/var/www/blog"},
+		{"outside allowed pattern", "/var/www/*", "127.0.0.1:4000", "/srv/site", ""},
+		{"relative root", "/var/www/*", "127.0.0.1:4000", "var/www/blog", ""},
+	}
+
+	for _, tt := range tests {
+		trust, err := ParseRootTrust(DefaultTrustedProxies, "", tt.roots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetRootTrust(trust)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Root-Dir", tt.header)
+
+		got, fromHeader, err := headerRoot(req, "/srv/site")
+		if !fromHeader {
+			t.Errorf("%s: the header should be reported as sent", tt.name)
+		}
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: X-Root-Dir %s was honoured as %q, want it refused", tt.name, tt.header, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: headerRoot = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestHeaderRootWithSecret(t *testing.T) {
+	defer func(trust RootTrust) { rootTrust = trust }(rootTrust)
+
+	trust, err := ParseRootTrust("", "a-long-shared-secret", "/var/www/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRootTrust(trust)
+
+	tests := []struct {
+		secret string
+		ok     bool
+	}{
+		{"a-long-shared-secret", true},
+		{"a-wrong-secret", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.7:4000"
+		req.Header.Set("X-Root-Dir", "/var/www/blog")
+		if tt.secret != "" {
+			req.Header.Set(proxySecretHeader, tt.secret)
+		}
+		if _, _, err := headerRoot(req, ""); (err == nil) != tt.ok {
+			t.Errorf("secret %q: err = %v, want honoured %v", tt.secret, err, tt.ok)
+		}
+	}
+}
+
+func TestVisitorIP(t *testing.T) {
+	defer func(trust RootTrust) { rootTrust = trust }(rootTrust)
+	trust, err := ParseRootTrust(DefaultTrustedProxies+",10.0.0.0/8", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRootTrust(trust)
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct visitor", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"direct visitor can't claim an address", "203.0.113.7:4000", map[string]string{"X-Real-IP": "198.51.100.1", "X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"proxy with X-Real-IP", "127.0.0.1:4000", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"proxy with X-Forwarded-For", "127.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed first hop is skipped", "127.0.0.1:4000", map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "127.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.5"}, "198.51.100.1"},
+		{"proxy without headers", "127.0.0.1:4000", nil, "127.0.0.1"},
+		{"garbage header", "127.0.0.1:4000", map[string]string{"X-Real-IP": "nope", "X-Forwarded-For": "nope"}, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		if got := visitorIP(req); got != tt.want {
+			t.Errorf("%s: visitorIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
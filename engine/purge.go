@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"zin-engine/directives"
+	"zin-engine/model"
+	"zin-engine/utils"
+)
+
+const cachePurgePath = "/zin-cache/purge"
+
+// HandleCachePurge drops cached zin-data sources of the site, and its cached routes when no src is given.
+// POST /zin-cache/purge?src=sheets:// with the CACHE_PURGE_TOKEN of .env in the X-Zin-Purge-Token header.
+func HandleCachePurge(req *http.Request, ctx *model.RequestContext) (int, string) {
+	token := utils.GetEnvValue(ctx, "CACHE_PURGE_TOKEN", "")
+	if token == "" {
+		return 404, `{"error":"Cache purging is disabled, set CACHE_PURGE_TOKEN in .env to enable it."}`
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Zin-Purge-Token")), []byte(token)) != 1 {
+		return 401, `{"error":"Invalid or missing X-Zin-Purge-Token."}`
+	}
+
+	match := req.URL.Query().Get("src")
+	data := directives.PurgeDataCache(ctx.Root, match)
+	routes := 0
+	if match == "" {
+		routes = routeCache.Purge(ctx.Root + "|")
+	}
+
+	return 200, fmt.Sprintf(`{"purged":{"data":%d,"routes":%d}}`, data, routes)
+}
+
+// Purge drops every route whose key starts with prefix
+func (rc *RouteCache) Purge(prefix string) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	purged := 0
+	for key := range rc.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(rc.entries, key)
+			purged++
+		}
+	}
+	for route := range rc.vary {
+		if strings.HasPrefix(route, prefix) {
+			delete(rc.vary, route)
+		}
+	}
+	return purged
+}
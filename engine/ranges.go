@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"zin-engine/model"
+)
+
+// Clients asking for more parts than this get the full file instead
+const maxRangeParts = 32
+
+var errUnsatisfiableRange = errors.New("requested range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange reads a "bytes=" Range header, nil ranges means the header is ignored
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	var total int64
+	for _, spec := range strings.Split(strings.TrimPrefix(header, "bytes="), ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		from, to, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+
+		var r byteRange
+		if from == "" {
+			// Suffix range e.g. -500 means the last 500 bytes
+			n, err := strconv.ParseInt(to, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(from, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if to != "" {
+				end, err = strconv.ParseInt(to, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+		total += r.length
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	// Too many parts or more bytes than the file itself, just send everything
+	if len(ranges) > maxRangeParts || total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// checkIfRange tells if the Range header still applies to the current file version
+func checkIfRange(ctx *model.RequestContext, etag string, modTime time.Time) bool {
+	ifRange := ctx.Headers["If-Range"]
+	if ifRange == "" {
+		return true
+	}
+
+	// Only strong validators are allowed in If-Range
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+
+	t, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
+
+// sendRanges answers a Range request, it returns false when the full file should be sent instead
+func sendRanges(w http.ResponseWriter, ctx *model.RequestContext, f *os.File, contentType string, size int64) bool {
+	ranges, err := parseRange(ctx.Headers["Range"], size)
+	if err != nil {
+		setDefaultHeaders(w, ctx, "text/plain")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.Header().Del("ETag")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	if ranges == nil {
+		return false
+	}
+
+	// Single part is sent as is
+	if len(ranges) == 1 {
+		r := ranges[0]
+		setDefaultHeaders(w, ctx, contentType)
+		w.Header().Set("Content-Range", r.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(r.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		io.Copy(w, io.NewSectionReader(f, r.start, r.length))
+		return true
+	}
+
+	// Multiple parts are wrapped in multipart/byteranges, body is sent chunked
+	mw := multipart.NewWriter(w)
+	setDefaultHeaders(w, ctx, "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {r.contentRange(size)},
+		})
+		if err != nil {
+			fmt.Printf(">> Range Error: %v\n", err)
+			return true
+		}
+		if _, err := io.Copy(part, io.NewSectionReader(f, r.start, r.length)); err != nil {
+			fmt.Printf(">> Range Error: %v\n", err)
+			return true
+		}
+	}
+	mw.Close()
+
+	return true
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange // nil when the whole file is sent
+		err    bool
+	}{
+		{"bytes=0-4", []byteRange{{0, 5}}, false},
+		{"bytes=6-", []byteRange{{6, 4}}, false},
+		{"bytes=-3", []byteRange{{7, 3}}, false},
+		{"bytes=-50", []byteRange{{0, 10}}, false},
+		{"bytes=8-50", []byteRange{{8, 2}}, false},
+		{"bytes=0-1, 4-5", []byteRange{{0, 2}, {4, 2}}, false},
+		{"bytes=0-2,20-30", []byteRange{{0, 3}}, false},
+		{"bytes=10-", nil, true},
+		{"bytes=10-20,30-", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=0-9,0-9", nil, false}, // more than the file
+		{"bytes=5-2", nil, false},
+		{"bytes=a-b", nil, false},
+		{"bytes=3", nil, false},
+		{"items=0-4", nil, false},
+		{"bytes=" + strings.Repeat("0-0,", maxRangeParts+1), nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.header, 10)
+		if (err != nil) != tt.err {
+			t.Errorf("parseRange(%q) err = %v, want error %v", tt.header, err, tt.err)
+			continue
+		}
+		if len(got) != len(tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{"notes.txt": "0123456789"})
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "notes.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	etag := request(t, root, "/notes.txt", nil).Header().Get("ETag")
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"single", map[string]string{"Range": "bytes=2-5"}, 206, "2345", "bytes 2-5/10"},
+		{"suffix", map[string]string{"Range": "bytes=-3"}, 206, "789", "bytes 7-9/10"},
+		{"open end", map[string]string{"Range": "bytes=8-"}, 206, "89", "bytes 8-9/10"},
+		{"unsatisfiable", map[string]string{"Range": "bytes=20-30"}, 416, "", "bytes */10"},
+		{"malformed", map[string]string{"Range": "bytes=x-y"}, 200, "0123456789", ""},
+		{"gzip is skipped", map[string]string{"Range": "bytes=0-0", "Accept-Encoding": "gzip"}, 206, "0", "bytes 0-0/10"},
+		{"if-range matches", map[string]string{"Range": "bytes=0-1", "If-Range": etag}, 206, "01", "bytes 0-1/10"},
+		{"if-range changed", map[string]string{"Range": "bytes=0-1", "If-Range": `"old"`}, 200, "0123456789", ""},
+		{"if-range date", map[string]string{"Range": "bytes=0-1", "If-Range": modTime.Format(http.TimeFormat)}, 206, "01", "bytes 0-1/10"},
+		{"if-range older date", map[string]string{"Range": "bytes=0-1", "If-Range": modTime.Add(-time.Hour).Format(http.TimeFormat)}, 200, "0123456789", ""},
+	}
+	for _, tt := range tests {
+		rec := request(t, root, "/notes.txt", tt.headers)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != 416 && rec.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, rec.Body.String(), tt.body)
+		}
+		if got := rec.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: Content-Range %q, want %q", tt.name, got, tt.contentRange)
+		}
+		if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("%s: Accept-Ranges %q", tt.name, got)
+		}
+	}
+}
+
+func TestMultipartRange(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{"notes.txt": "0123456789"})
+
+	rec := request(t, root, "/notes.txt", map[string]string{"Range": "bytes=0-1,5-6,-1"})
+	if rec.Code != 206 {
+		t.Fatalf("status %d, want 206", rec.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, %v", rec.Header().Get("Content-Type"), err)
+	}
+
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 5-6/10", "56"},
+		{"bytes 9-9/10", "9"},
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	for i, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != w.contentRange || string(body) != w.body {
+			t.Errorf("part %d = %q %q, want %q %q", i, got, body, w.contentRange, w.body)
+		}
+		if got := part.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+			t.Errorf("part %d Content-Type = %q", i, got)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("want 3 parts, got more: %v", err)
+	}
+}
@@ -1,64 +1,81 @@
 package engine
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 	"zin-engine/model"
 	"zin-engine/utils"
 )
 
-func ConnTimeOut(conn net.Conn) {
-	status := 408
-	conn.Write([]byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))))
-	conn.Write([]byte("Content-Type: text/plain\r\n"))
-	conn.Write([]byte("Content-Length: 0\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("\r\n"))
-	conn.Write([]byte(fmt.Sprintf("%d %s", status, http.StatusText(status))))
+// setDefaultHeaders sets the headers shared by every response sent by the engine
+func setDefaultHeaders(w http.ResponseWriter, ctx *model.RequestContext, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Parser", ctx.ServerVersion)
 }
 
-func PrintErrorOnClient(conn net.Conn, status int, path string, content string) {
+func ConnTimeOut(w http.ResponseWriter, ctx *model.RequestContext) {
+	status := http.StatusRequestTimeout
+	content := fmt.Sprintf("%d %s", status, http.StatusText(status))
+
+	setDefaultHeaders(w, ctx, "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(status)
+	io.WriteString(w, content)
+}
+
+func PrintErrorOnClient(w http.ResponseWriter, ctx *model.RequestContext, status int, path string, content string) {
 	// Get final content to print on client
-	content = utils.GetStatusCodeFileContent(status, rootDir, content)
+	content = utils.GetStatusCodeFileContent(status, ctx.Root, content)
 
 	// Write the HTTP response
-	conn.Write([]byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))))
-	conn.Write([]byte("Content-Type: text/html\r\n"))
-	conn.Write([]byte(fmt.Sprintf("Content-Length: %d\r\n", len(content))))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("\r\n"))
-	conn.Write([]byte(content))
-
+	setDefaultHeaders(w, ctx, "text/html")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	io.WriteString(w, content)
 }
 
-func Favicon(conn net.Conn, rootDir string) {
-	path := utils.GetFaviconIconPath(rootDir, "/favicon.ico")
+func Favicon(w http.ResponseWriter, ctx *model.RequestContext) {
+	path := utils.GetFaviconIconPath(ctx.Root, "/favicon.ico")
 
 	// Open the favicon file
 	f, err := os.Open(path)
 	if err != nil {
-		PrintErrorOnClient(conn, 404, path, "Error: Favicon icon not found")
+		PrintErrorOnClient(w, ctx, 404, path, "Error: Favicon icon not found")
 		return
 	}
 	defer f.Close()
 
+	info, err := f.Stat()
+	if err != nil {
+		PrintErrorOnClient(w, ctx, 404, path, "Error: Favicon icon not found")
+		return
+	}
+
+	// Let the browser reuse its copy if unchanged
+	etag := fileETag(info)
+	setCacheHeaders(w, ctx, "CACHE_CONTROL_FAVICON", defaultFaviconCacheControl, etag, info.ModTime())
+	if isNotModified(ctx, etag, info.ModTime()) {
+		writeNotModified(w, ctx)
+		return
+	}
+
 	// Write a minimal HTTP response header for the favicon
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	conn.Write([]byte("Content-Type: image/x-icon\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("Cache-Control: max-age=86400\r\n"))
-	conn.Write([]byte("\r\n"))
-
-	// Copy the file contents to the connection
-	io.Copy(conn, f)
+	setDefaultHeaders(w, ctx, "image/x-icon")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+
+	// Copy the file contents to the client
+	io.Copy(w, f)
 }
 
-func SendRawFile(conn net.Conn, ctx *model.RequestContext) {
+func SendRawFile(w http.ResponseWriter, ctx *model.RequestContext) {
 
 	path := ctx.ContentSource
 	contentType := ctx.ContentType
@@ -67,84 +84,97 @@ func SendRawFile(conn net.Conn, ctx *model.RequestContext) {
 	// Open the file
 	f, err := os.Open(path)
 	if err != nil {
-		PrintErrorOnClient(conn, 404, path, fmt.Sprintf("Error: Unable to find file `%s`. %s", path, err.Error()))
+		PrintErrorOnClient(w, ctx, 404, path, fmt.Sprintf("Error: Unable to find file `%s`. %s", path, err.Error()))
 		return
 	}
 	defer f.Close()
 
 	// Get file info (to read size)
 	info, err := f.Stat()
-	if err != nil || info.IsDir() {
-		PrintErrorOnClient(conn, 404, path, fmt.Sprintf("Error: A directory can't be renders on clint. %s", err.Error()))
+	if err != nil {
+		PrintErrorOnClient(w, ctx, 404, path, fmt.Sprintf("Error: Unable to read file `%s`. %s", path, err.Error()))
+		return
+	}
+	if info.IsDir() {
+		PrintErrorOnClient(w, ctx, 404, path, "Error: A directory can't be renders on clint.")
+		return
+	}
+
+	// Byte ranges always refer to the uncompressed file
+	hasRange := ctx.Headers["Range"] != ""
+	useGzip := ctx.GzipCompression && !hasRange
+
+	// Let the client reuse its copy if unchanged
+	etag := representationETag(fileETag(info), useGzip)
+	setCacheHeaders(w, ctx, "CACHE_CONTROL_STATIC", defaultStaticCacheControl, etag, info.ModTime())
+	if isNotModified(ctx, etag, info.ModTime()) {
+		writeNotModified(w, ctx)
+		return
+	}
+
+	// Serve partial content (video seeking, resumed downloads)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if hasRange && checkIfRange(ctx, etag, info.ModTime()) && sendRanges(w, ctx, f, contentType, info.Size()) {
 		return
 	}
 
-	// Try compression first
-	if ctx.GzipCompression {
-		err := trySendCompressed(conn, f, contentType)
-		if err == nil {
-			return
+	// Try compression first, headers are already sent so a failure can only be logged
+	if useGzip {
+		if err := trySendCompressed(w, ctx, f, contentType); err != nil {
+			fmt.Printf(">> Gzip Error: %v\n", err)
 		}
-		// Reset file reader
-		f.Seek(0, io.SeekStart)
+		return
 	}
 
 	// Send uncompressed
-	sendUncompressed(conn, f, contentType, info.Size())
+	sendUncompressed(w, ctx, f, contentType, info.Size())
 }
 
-func JsonResponse(conn net.Conn, status int, content string) {
-	conn.Write([]byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))))
-	conn.Write([]byte("Content-Type: application/json\r\n"))
-	conn.Write([]byte(fmt.Sprintf("Content-Length: %d\r\n", len(content))))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("\r\n"))
-	conn.Write([]byte(content))
+func JsonResponse(w http.ResponseWriter, ctx *model.RequestContext, status int, content string) {
+	setDefaultHeaders(w, ctx, "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	io.WriteString(w, content)
 }
 
-func Redirect(conn net.Conn, status int, location string) {
+func Redirect(w http.ResponseWriter, ctx *model.RequestContext, status int, location string) {
 	if status < 300 || status > 399 {
 		status = 302 // Default to temporary redirect
 	}
 
-	conn.Write([]byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))))
-	conn.Write([]byte("Location: " + location + "\r\n"))
-	conn.Write([]byte("Content-Length: 0\r\n"))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("\r\n"))
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Parser", ctx.ServerVersion)
+	w.WriteHeader(status)
 }
 
-func SendPageContent(conn net.Conn, content string, ctx *model.RequestContext) {
+func SendPageContent(w http.ResponseWriter, content string, ctx *model.RequestContext) {
+
+	// Rendered pages have no file time, the content hash alone validates them
+	etag := representationETag(contentETag(content), ctx.GzipCompression)
+	setCacheHeaders(w, ctx, "CACHE_CONTROL_PAGE", defaultPageCacheControl, etag, time.Time{})
+	if isNotModified(ctx, etag, time.Time{}) {
+		writeNotModified(w, ctx)
+		return
+	}
 
 	// Send uncompressed is not requested
 	if !ctx.GzipCompression {
-		writePlainContent(conn, content)
+		writePlainContent(w, ctx, content)
 		return
 	}
 
-	// Try-sending gzip-compressed content
-	err := writeGzipContent(conn, content)
-	if err != nil {
-		// Send fallback uncompressed response
-		writePlainContent(conn, content)
+	// Send gzip-compressed content, headers are already sent so a failure can only be logged
+	if err := writeGzipContent(w, ctx, content); err != nil {
+		fmt.Printf(">> Gzip Error: %v\n", err)
 	}
-
 }
 
-func writeGzipContent(conn net.Conn, content string) error {
-	// Headers
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	conn.Write([]byte("Content-Type: text/html\r\n"))
-	conn.Write([]byte("Content-Encoding: gzip\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("\r\n"))
-
-	// Body
-	gz := gzip.NewWriter(conn)
-	_, err := gz.Write([]byte(content))
+func writeGzipContent(w http.ResponseWriter, ctx *model.RequestContext, content string) error {
+	// Body - compressed upfront so Content-Length is known, HEAD included
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	_, err := io.WriteString(gz, content)
 	if err != nil {
 		return fmt.Errorf("error writing gzip content: %w", err)
 	}
@@ -154,49 +184,79 @@ func writeGzipContent(conn net.Conn, content string) error {
 		return fmt.Errorf("error closing gzip writer: %w", err)
 	}
 
-	return nil
+	// Headers
+	setDefaultHeaders(w, ctx, "text/html")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = body.WriteTo(w)
+	return err
 }
 
-func writePlainContent(conn net.Conn, content string) {
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	conn.Write([]byte("Content-Type: text/html\r\n"))
-	conn.Write([]byte(fmt.Sprintf("Content-Length: %d\r\n", len(content))))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("\r\n"))
-	conn.Write([]byte(content))
+func writePlainContent(w http.ResponseWriter, ctx *model.RequestContext, content string) {
+	setDefaultHeaders(w, ctx, "text/html")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, content)
 }
 
-func sendUncompressed(conn net.Conn, f *os.File, contentType string, contentLength int64) {
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	conn.Write([]byte("Content-Type: " + contentType + "\r\n"))
-	conn.Write([]byte(fmt.Sprintf("Content-Length: %d\r\n", contentLength)))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("\r\n"))
-	io.Copy(conn, f)
+func sendUncompressed(w http.ResponseWriter, ctx *model.RequestContext, f *os.File, contentType string, contentLength int64) {
+	setDefaultHeaders(w, ctx, contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, f)
 }
 
-func trySendCompressed(conn net.Conn, f *os.File, contentType string) error {
-	// Headers
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	conn.Write([]byte("Content-Type: " + contentType + "\r\n"))
-	conn.Write([]byte("Content-Encoding: gzip\r\n"))
-	conn.Write([]byte("Parser: " + zinVersion + "\r\n"))
-	conn.Write([]byte("Connection: close\r\n"))
-	conn.Write([]byte("\r\n"))
-
-	gz := gzip.NewWriter(conn)
-	defer gz.Close()
-
-	// Try flushing header
-	if err := gz.Flush(); err != nil {
-		return fmt.Errorf("failed to flush gzip header: %w", err)
+func trySendCompressed(w http.ResponseWriter, ctx *model.RequestContext, f *os.File, contentType string) error {
+	// Headers - Content-Length is unknown so the body is sent chunked
+	setDefaultHeaders(w, ctx, contentType)
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// HEAD has no body to chunk, so measure the compressed size instead
+	if ctx.Method == http.MethodHead {
+		size, err := gzipSize(f)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		return nil
 	}
 
+	w.WriteHeader(http.StatusOK)
+
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+
 	if _, err := io.Copy(gz, f); err != nil {
 		return fmt.Errorf("gzip copy failed:: %w", err)
 	}
 
 	return nil
 }
+
+// countingWriter discards everything written to it and keeps the byte count
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// gzipSize returns the length the file would have once gzip compressed
+func gzipSize(f *os.File) (int64, error) {
+	var size countingWriter
+	gz := gzip.NewWriter(&size)
+	if _, err := io.Copy(gz, f); err != nil {
+		return 0, fmt.Errorf("gzip size failed:: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("gzip size failed:: %w", err)
+	}
+	return int64(size), nil
+}
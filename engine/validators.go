@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"zin-engine/model"
+	"zin-engine/utils"
+)
+
+// Cache-Control defaults, each can be overridden from the site .env
+const (
+	defaultStaticCacheControl  = "no-cache"
+	defaultPageCacheControl    = "no-cache"
+	defaultFaviconCacheControl = "max-age=86400"
+)
+
+// fileETag builds a strong validator from the file size and modification time
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// contentETag builds a strong validator from the rendered content
+func contentETag(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// representationETag keeps gzip and identity bodies apart as they are different bytes
+func representationETag(etag string, gzip bool) string {
+	if !gzip {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + `-gzip"`
+}
+
+// setCacheHeaders writes the validators & Cache-Control configured under envKey
+func setCacheHeaders(w http.ResponseWriter, ctx *model.RequestContext, envKey string, defaultValue string, etag string, modTime time.Time) {
+	w.Header().Set("Cache-Control", utils.GetEnvValue(ctx, envKey, defaultValue))
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// isNotModified checks If-None-Match first and falls back to If-Modified-Since
+func isNotModified(ctx *model.RequestContext, etag string, modTime time.Time) bool {
+	if match := ctx.Headers["If-None-Match"]; match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := ctx.Headers["If-Modified-Since"]; since != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && !modTime.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeNotModified ends the response with 304, validators must already be set
+func writeNotModified(w http.ResponseWriter, ctx *model.RequestContext) {
+	fmt.Printf(">> Not Modified: %s\n", ctx.Path)
+	w.Header().Del("Content-Type")
+	w.Header().Set("Parser", ctx.ServerVersion)
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// request sends a GET with the given headers to a site at root
+func request(t *testing.T, root string, target string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	HandleRequest(rec, req, root, "zin/test")
+	return rec
+}
+
+func TestStaticFileValidators(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{"notes.txt": "hello world"})
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "notes.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	first := request(t, root, "/notes.txt", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || etag == "" {
+		t.Fatalf("first GET: status %d, ETag %q", first.Code, etag)
+	}
+	if got := first.Header().Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q", got)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"same etag", map[string]string{"If-None-Match": etag}, 304},
+		{"etag in a list", map[string]string{"If-None-Match": `"other", ` + etag}, 304},
+		{"weak form of the etag", map[string]string{"If-None-Match": "W/" + etag}, 304},
+		{"any", map[string]string{"If-None-Match": "*"}, 304},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, 200},
+		{"gzip etag for identity", map[string]string{"If-None-Match": representationETag(etag, true)}, 200},
+		{"not modified since", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, 304},
+		{"modified since", map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, 200},
+		{"etag wins over date", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modTime.Format(http.TimeFormat)}, 200},
+	}
+	for _, tt := range tests {
+		rec := request(t, root, "/notes.txt", tt.headers)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if rec.Code == 304 && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag) {
+			t.Errorf("%s: 304 should have no body and keep the ETag, got %q and %q", tt.name, rec.Body.String(), rec.Header().Get("ETag"))
+		}
+	}
+
+	// Changing the file changes the validator
+	writeSite(t, root, map[string]string{"notes.txt": "hello again"})
+	if rec := request(t, root, "/notes.txt", map[string]string{"If-None-Match": etag}); rec.Code != 200 {
+		t.Errorf("changed file: status %d, want 200", rec.Code)
+	}
+}
+
+func TestPageValidators(t *testing.T) {
+	root := t.TempDir()
+	writeSite(t, root, map[string]string{"index.html": "<p>{{ name }}</p>"})
+
+	first := request(t, root, "/?name=ann", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || etag == "" || first.Header().Get("Last-Modified") != "" {
+		t.Fatalf("first GET: status %d, ETag %q, Last-Modified %q", first.Code, etag, first.Header().Get("Last-Modified"))
+	}
+
+	if rec := request(t, root, "/?name=ann", map[string]string{"If-None-Match": etag}); rec.Code != 304 {
+		t.Errorf("same page: status %d, want 304", rec.Code)
+	}
+	if rec := request(t, root, "/?name=bob", map[string]string{"If-None-Match": etag}); rec.Code != 200 {
+		t.Errorf("other content: status %d, want 200", rec.Code)
+	}
+
+	gzipped := request(t, root, "/?name=ann", map[string]string{"Accept-Encoding": "gzip"})
+	if got := gzipped.Header().Get("ETag"); got == etag || got != representationETag(etag, true) {
+		t.Errorf("gzip ETag = %q, want it apart from %q", got, etag)
+	}
+}
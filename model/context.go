@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"database/sql"
 	"net/url"
 )
@@ -12,6 +13,7 @@ type CustomVar struct {
 }
 
 type RequestContext struct {
+	Context         context.Context // cancelled when the request times out or the client goes away
 	ClientIp        string
 	Method          string
 	Host            string
@@ -22,10 +24,14 @@ type RequestContext struct {
 	ServerVersion   string
 	ServerError     map[string]string
 	Query           url.Values
+	QueryReads      map[string]bool // query params the page looked up, "*" when it used all of them
 	Headers         map[string]string
 	CustomVar       CustomVar
 	ENV             map[string]string
 	LocalVar        map[string]string
 	SqlConn         *sql.DB
 	GzipCompression bool
+	InlineErrors    bool
+	DocumentOrder   bool
+	Uncacheable     bool // the output is per visitor (form tokens, random values, times), never kept in the route cache
 }
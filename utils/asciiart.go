@@ -2,7 +2,7 @@ package utils
 
 import "fmt"
 
-func PrintASCII(port string, rootDir string, version string) {
+func PrintASCII(scheme string, port string, rootDir string, version string) {
 	if rootDir == "" {
 		rootDir = "<unspecified>"
 	}
@@ -15,5 +15,5 @@ func PrintASCII(port string, rootDir string, version string) {
 	fmt.Println("    \\|_______|\\|__|\\|__| \\|__|		")
 	fmt.Printf("\n• Version : %s", version)
 	fmt.Printf("\n• Source  : %s", rootDir)
-	fmt.Printf("\n• Local   : http://127.0.0.1:%s\n", port)
+	fmt.Printf("\n• Local   : %s://127.0.0.1:%s\n", scheme, port)
 }
@@ -3,12 +3,18 @@ package utils
 import (
 	"fmt"
 	"html"
+	pathpkg "path"
 	"path/filepath"
 	"regexp"
 	"strings"
 )
 
-func GetFilePathFromURI(path string) string {
+// GetFilePathFromURI maps a request path to the site file it serves, dot segments are resolved first
+func GetFilePathFromURI(uri string) string {
+	path := pathpkg.Clean("/" + uri)
+	if strings.HasSuffix(uri, "/") && path != "/" {
+		path += "/"
+	}
 
 	if path == "/" {
 		path = "/index.html"
@@ -41,10 +47,11 @@ func InjectZinScriptAndStyle(content string) string {
 	return content
 }
 
+var tagAttrRegex = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+
 func ExtractAttributesFromTag(attr string) map[string]string {
 	// Parse attributes into key-value map
-	attrRe := regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
-	attributes := attrRe.FindAllStringSubmatch(attr, -1)
+	attributes := tagAttrRegex.FindAllStringSubmatch(attr, -1)
 
 	zinTagAttr := make(map[string]string)
 	for _, attr := range attributes {
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"zin-engine/model"
+)
+
+// zin-form sessions are sealed with the FORM_SECRET of the site .env (32+ characters).
+// A token expires after FORM_TOKEN_TTL (default 2h), can be submitted once, and with
+// FORM_BIND_IP=ON only from the IP it was issued to. Behind a proxy that IP comes from X-Real-IP or
+// X-Forwarded-For, so the proxy must be in -trusted-proxies or every visitor shares the proxy's address.
+
+const (
+	minFormSecret       = 32
+	defaultFormTokenTTL = 2 * time.Hour
+)
+
+// FormToken is the session of a rendered zin-form, the client can't read or change it
+type FormToken struct {
+	Action     string `json:"action"`
+	Captcha    string `json:"captcha"`
+	Validators string `json:"validators"`
+	ClientIp   string `json:"ip,omitempty"`
+	Nonce      string `json:"nonce"`
+	IssuedAt   int64  `json:"iat"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+var (
+	ErrFormTokenInvalid = errors.New("form session token is tampered or not from this site, reload the page and try again")
+	ErrFormTokenExpired = errors.New("form session token is expired, reload the page and try again")
+	ErrFormTokenUsed    = errors.New("form was already submitted, reload the page to send it again")
+	ErrFormTokenIP      = errors.New("form session token was issued to another network, reload the page and try again")
+)
+
+// SealFormToken fills in nonce, times & IP binding, then encrypts the token for the form formId
+func SealFormToken(ctx *model.RequestContext, formId string, token FormToken) (string, error) {
+	aead, err := formCipher(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ttl, err := envDuration(ctx.ENV, "FORM_TOKEN_TTL", defaultFormTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	if ttl == 0 {
+		ttl = defaultFormTokenTTL
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	token.Nonce = hex.EncodeToString(nonce)
+	token.IssuedAt = now.Unix()
+	token.ExpiresAt = now.Add(ttl).Unix()
+	token.ClientIp = ""
+	if GetEnvValue(ctx, "FORM_BIND_IP", "OFF") == "ON" {
+		token.ClientIp = ctx.ClientIp
+	}
+
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(iv, iv, plain, formTokenAAD(ctx, formId))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// OpenFormToken decrypts & checks a token, ConsumeFormToken spends it once the submission is accepted
+func OpenFormToken(ctx *model.RequestContext, formId string, sealed string) (FormToken, error) {
+	var token FormToken
+
+	aead, err := formCipher(ctx)
+	if err != nil {
+		return token, err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return token, ErrFormTokenInvalid
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], formTokenAAD(ctx, formId))
+	if err != nil {
+		return token, ErrFormTokenInvalid
+	}
+	if err := json.Unmarshal(plain, &token); err != nil || token.Nonce == "" {
+		return token, ErrFormTokenInvalid
+	}
+
+	now := time.Now().Unix()
+	if now >= token.ExpiresAt || token.IssuedAt > now+60 {
+		return token, ErrFormTokenExpired
+	}
+	if token.ClientIp != "" && token.ClientIp != ctx.ClientIp {
+		return token, ErrFormTokenIP
+	}
+
+	return token, nil
+}
+
+// ConsumeFormToken marks the token as submitted, false when it already was
+func ConsumeFormToken(token FormToken) bool {
+	return formNonces.use(token.Nonce, time.Unix(token.ExpiresAt, 0))
+}
+
+// ReleaseFormToken hands back a consumed token whose submission didn't go through
+func ReleaseFormToken(token FormToken) {
+	formNonces.release(token.Nonce)
+}
+
+func formCipher(ctx *model.RequestContext) (cipher.AEAD, error) {
+	secret := GetEnvValue(ctx, "FORM_SECRET", "")
+	if len(secret) < minFormSecret {
+		return nil, fmt.Errorf("set FORM_SECRET in .env to a random value of at least %d characters to use zin-form", minFormSecret)
+	}
+
+	key := sha256.Sum256([]byte("zin-form|" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// formTokenAAD ties a token to its site and form, it can't be moved to another one
+func formTokenAAD(ctx *model.RequestContext, formId string) []byte {
+	return []byte(ctx.Root + "|" + formId)
+}
+
+// nonceStore remembers submitted tokens until they expire anyway
+type nonceStore struct {
+	mu        sync.Mutex
+	used      map[string]time.Time
+	lastPrune time.Time
+}
+
+var formNonces = &nonceStore{used: make(map[string]time.Time)}
+
+func (ns *nonceStore) use(nonce string, expires time.Time) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ns.lastPrune) > time.Minute {
+		for n, exp := range ns.used {
+			if now.After(exp) {
+				delete(ns.used, n)
+			}
+		}
+		ns.lastPrune = now
+	}
+
+	if _, ok := ns.used[nonce]; ok {
+		return false
+	}
+	ns.used[nonce] = expires
+	return true
+}
+
+func (ns *nonceStore) release(nonce string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	delete(ns.used, nonce)
+}
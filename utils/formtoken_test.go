@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"zin-engine/model"
+)
+
+func formSite(t *testing.T) *model.RequestContext {
+	t.Helper()
+	return &model.RequestContext{
+		ClientIp: "203.0.113.7",
+		Root:     t.TempDir(),
+		ENV:      map[string]string{"FORM_SECRET": "0123456789abcdef0123456789abcdef"},
+	}
+}
+
+func TestFormTokenRoundTrip(t *testing.T) {
+	ctx := formSite(t)
+	sealed, err := SealFormToken(ctx, "contact", FormToken{Action: "https://api.example.test/contact", Captcha: "GOOGLE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := OpenFormToken(ctx, "contact", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Action != "https://api.example.test/contact" || token.Captcha != "GOOGLE" || token.Nonce == "" {
+		t.Errorf("opened token = %+v", token)
+	}
+}
+
+func TestFormTokenRejected(t *testing.T) {
+	site := formSite(t)
+	sealed, err := SealFormToken(site, "contact", FormToken{Action: "https://api.example.test/contact"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(sealed)
+	raw[len(raw)-1] ^= 1
+	flipped := base64.RawURLEncoding.EncodeToString(raw)
+
+	otherSite := formSite(t)
+	otherSecret := formSite(t)
+	otherSecret.Root = site.Root
+	otherSecret.ENV["FORM_SECRET"] = "fedcba9876543210fedcba9876543210"
+
+	tests := []struct {
+		name   string
+		ctx    *model.RequestContext
+		formId string
+		sealed string
+		want   error
+	}{
+		{"changed byte", site, "contact", flipped, ErrFormTokenInvalid},
+		{"not base64", site, "contact", "not*a*token", ErrFormTokenInvalid},
+		{"too short", site, "contact", "AAAA", ErrFormTokenInvalid},
+		{"other form", site, "signup", sealed, ErrFormTokenInvalid},
+		{"other site", otherSite, "contact", sealed, ErrFormTokenInvalid},
+		{"other secret", otherSecret, "contact", sealed, ErrFormTokenInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := OpenFormToken(tt.ctx, tt.formId, tt.sealed); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFormTokenExpires(t *testing.T) {
+	ctx := formSite(t)
+	ctx.ENV["FORM_TOKEN_TTL"] = "1ns"
+	sealed, err := SealFormToken(ctx, "contact", FormToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFormToken(ctx, "contact", sealed); !errors.Is(err, ErrFormTokenExpired) {
+		t.Errorf("err = %v, want %v", err, ErrFormTokenExpired)
+	}
+
+	ctx.ENV["FORM_TOKEN_TTL"] = "soon"
+	if _, err := SealFormToken(ctx, "contact", FormToken{}); err == nil {
+		t.Error("an invalid FORM_TOKEN_TTL should be reported")
+	}
+}
+
+func TestFormTokenBoundToIP(t *testing.T) {
+	ctx := formSite(t)
+	ctx.ENV["FORM_BIND_IP"] = "ON"
+	sealed, err := SealFormToken(ctx, "contact", FormToken{ClientIp: "198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFormToken(ctx, "contact", sealed); err != nil {
+		t.Errorf("same client: %v", err)
+	}
+	ctx.ClientIp = "198.51.100.1"
+	if _, err := OpenFormToken(ctx, "contact", sealed); !errors.Is(err, ErrFormTokenIP) {
+		t.Errorf("other client: err = %v, want %v", err, ErrFormTokenIP)
+	}
+}
+
+func TestFormTokenReplay(t *testing.T) {
+	ctx := formSite(t)
+	sealed, err := SealFormToken(ctx, "contact", FormToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := OpenFormToken(ctx, "contact", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ConsumeFormToken(token) {
+		t.Fatal("first submission should be accepted")
+	}
+	if ConsumeFormToken(token) {
+		t.Fatal("a token must not be submitted twice")
+	}
+	ReleaseFormToken(token)
+	if !ConsumeFormToken(token) {
+		t.Error("a released token should be accepted again")
+	}
+}
+
+func TestFormTokenNeedsSecret(t *testing.T) {
+	ctx := formSite(t)
+	ctx.ENV["FORM_SECRET"] = "too-short"
+	if _, err := SealFormToken(ctx, "contact", FormToken{}); err == nil {
+		t.Error("a short FORM_SECRET should be refused")
+	}
+}
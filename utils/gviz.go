@@ -11,10 +11,11 @@ import (
 	"zin-engine/model"
 )
 
+// Case-insensitive regex to match FROM clause: FROM SheetId.SheetName
+var sheetFromRegex = regexp.MustCompile(`(?i)\s+from\s+([a-zA-Z0-9_-]+)\.([a-zA-Z0-9_-]+)`)
+
 func ParseSheetQuery(query string) (*model.SheetQueryResult, error) {
-	// Case-insensitive regex to match FROM clause: FROM SheetId.SheetName
-	re := regexp.MustCompile(`(?i)\s+from\s+([a-zA-Z0-9_-]+)\.([a-zA-Z0-9_-]+)`)
-	matches := re.FindStringSubmatch(query)
+	matches := sheetFromRegex.FindStringSubmatch(query)
 	if len(matches) != 3 {
 		return nil, fmt.Errorf("invalid query format, expected FROM SheetId.SheetName")
 	}
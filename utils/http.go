@@ -1,41 +1,101 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 	"zin-engine/model"
 )
 
-func Get(ctx *model.RequestContext, url string, varName string) error {
+// Defaults of an http(s) zin-data source
+const (
+	DefaultHTTPTimeout  = 10 * time.Second
+	DefaultHTTPMaxBytes = 5 << 20
+)
+
+// HTTPOptions shape the request of an http(s) zin-data source
+type HTTPOptions struct {
+	Method   string
+	Body     string
+	Headers  map[string]string
+	Auth     string // name of the HTTP_AUTH_<name>_* credentials in .env
+	Timeout  time.Duration
+	MaxBytes int64
+}
+
+// RequestContext is the context of the client request, cancelled on timeout or when the client goes away
+func RequestContext(ctx *model.RequestContext) context.Context {
+	if ctx.Context == nil {
+		return context.Background()
+	}
+	return ctx.Context
+}
+
+// Fetch loads url into varName: JSON as an object or list, CSV as a list, anything else as text
+func Fetch(ctx *model.RequestContext, url string, varName string, opts HTTPOptions) error {
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHTTPTimeout
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultHTTPMaxBytes
+	}
+
+	reqCtx, cancel := context.WithTimeout(RequestContext(ctx), opts.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if opts.Body != "" {
+		body = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, opts.Method, url, body)
+	if err != nil {
+		return fmt.Errorf("invalid request: %s %s Error:%v", opts.Method, url, err)
+	}
+	req.Header.Set("User-Agent", ctx.ServerVersion)
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if err := setAuthHeader(ctx, req, opts.Auth); err != nil {
+		return err
+	}
 
-	resp, err := http.Get(url)
+	resp, err := OutboundClient(ctx).Do(req)
 	if err != nil {
 		return fmt.Errorf("error fetching URL: %s Error:%v", url, err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("failed to load GET \"%s\": Status Code %d: Failed to fetch data", url, resp.StatusCode)
+		return fmt.Errorf("failed to load %s \"%s\": Status Code %d: Failed to fetch data", opts.Method, url, resp.StatusCode)
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	content, err := io.ReadAll(io.LimitReader(resp.Body, opts.MaxBytes+1))
 	if err != nil {
 		return fmt.Errorf("unable to read response content.  %v", err)
 	}
+	if int64(len(content)) > opts.MaxBytes {
+		return fmt.Errorf("response of \"%s\" is larger than %d bytes, raise max-bytes to load it", url, opts.MaxBytes)
+	}
 
 	// Set data according to response content
 	// JSON
 	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
 		var obj map[string]any
-		if err := json.Unmarshal(body, &obj); err == nil {
+		if err := json.Unmarshal(content, &obj); err == nil {
 			ctx.CustomVar.JSON[varName] = obj
 		} else {
 			// If not a map, try as Array (list)
 			var arr []any
-			if err := json.Unmarshal(body, &arr); err == nil {
+			if err := json.Unmarshal(content, &arr); err == nil {
 				if ctx.CustomVar.LIST == nil {
 					ctx.CustomVar.LIST = make(map[string][]any)
 				}
@@ -47,13 +107,41 @@ func Get(ctx *model.RequestContext, url string, varName string) error {
 	}
 
 	// CSV
-	content := string(body)
 	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
-		return CsvToContextList(ctx, varName, content)
+		return CsvToContextList(ctx, varName, string(content))
 	}
 
 	// Consider all other as Text
-	ctx.CustomVar.Raw[varName] = content
+	ctx.CustomVar.Raw[varName] = string(content)
 
 	return nil
 }
+
+// AuthFingerprint identifies the credentials named by auth without revealing them, "" without auth
+func AuthFingerprint(ctx *model.RequestContext, name string) string {
+	if name == "" {
+		return ""
+	}
+	prefix := "HTTP_AUTH_" + name + "_"
+	sum := sha256.Sum256([]byte(GetEnvValue(ctx, prefix+"TOKEN", "") + "\x00" + GetEnvValue(ctx, prefix+"USER", "") + "\x00" + GetEnvValue(ctx, prefix+"PASS", "")))
+	return name + ":" + hex.EncodeToString(sum[:16])
+}
+
+// setAuthHeader adds credentials kept in .env, HTTP_AUTH_<name>_TOKEN for bearer
+// or HTTP_AUTH_<name>_USER & HTTP_AUTH_<name>_PASS for basic auth
+func setAuthHeader(ctx *model.RequestContext, req *http.Request, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	prefix := "HTTP_AUTH_" + name + "_"
+	if token := GetEnvValue(ctx, prefix+"TOKEN", ""); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if user := GetEnvValue(ctx, prefix+"USER", ""); user != "" {
+		req.SetBasicAuth(user, GetEnvValue(ctx, prefix+"PASS", ""))
+		return nil
+	}
+	return fmt.Errorf("auth '%s' is not configured, set %sTOKEN or %sUSER & %sPASS in .env", name, prefix, prefix, prefix)
+}
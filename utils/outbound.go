@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"zin-engine/model"
+)
+
+// Outbound requests (http zin-data & zin-form forwarding) follow the policy of the site, set in its .env:
+//
+//	OUTBOUND_ALLOW=api.example.com, *.example.org, 203.0.113.0/24   (optional, only these may be reached)
+//	OUTBOUND_DENY=metrics.example.com, 198.51.100.0/24               (optional, never reached)
+//	OUTBOUND_ALLOW_PRIVATE=ON                                         (reach loopback, private & link-local addresses)
+//
+// Private addresses stay blocked unless OUTBOUND_ALLOW_PRIVATE is ON or an OUTBOUND_ALLOW range holds them.
+// The policy is checked by the dialer against the address actually connected to, so redirects and
+// names resolving to internal addresses are caught too.
+
+// ErrOutboundBlocked is returned for a request the outbound policy refuses
+var ErrOutboundBlocked = errors.New("blocked by the outbound policy of the site")
+
+type outboundPolicy struct {
+	allowHosts   []string
+	allowNets    []*net.IPNet
+	denyHosts    []string
+	denyNets     []*net.IPNet
+	allowPrivate bool
+}
+
+// Ranges not covered by net.IP's own checks that still aren't public
+var reservedNets = parseNets("100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "64:ff9b::/96")
+
+var (
+	outboundClients   = make(map[string]*http.Client) // keyed by the policy, so sites sharing one share connections
+	outboundClientsMu sync.Mutex
+)
+
+// OutboundClient returns the http client for requests leaving the server on behalf of the site
+func OutboundClient(ctx *model.RequestContext) *http.Client {
+	key := strings.Join([]string{
+		GetEnvValue(ctx, "OUTBOUND_ALLOW", ""),
+		GetEnvValue(ctx, "OUTBOUND_DENY", ""),
+		GetEnvValue(ctx, "OUTBOUND_ALLOW_PRIVATE", "OFF"),
+	}, "\n")
+
+	outboundClientsMu.Lock()
+	defer outboundClientsMu.Unlock()
+
+	if client, ok := outboundClients[key]; ok {
+		return client
+	}
+
+	policy := loadOutboundPolicy(ctx)
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would connect for us and skip the checks
+		DialContext:           policy.dialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	client := &http.Client{Transport: transport}
+	outboundClients[key] = client
+	return client
+}
+
+// CheckOutboundURL tells early if a URL is refused by name, addresses are only known once dialed
+func CheckOutboundURL(ctx *model.RequestContext, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("'%s' is not a valid http(s) URL", rawURL)
+	}
+
+	policy := loadOutboundPolicy(ctx)
+	host := strings.ToLower(u.Hostname())
+	if _, err := policy.checkHost(host); err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return policy.checkIP(ip, false)
+	}
+	return nil
+}
+
+func loadOutboundPolicy(ctx *model.RequestContext) *outboundPolicy {
+	p := &outboundPolicy{allowPrivate: GetEnvValue(ctx, "OUTBOUND_ALLOW_PRIVATE", "OFF") == "ON"}
+	p.allowHosts, p.allowNets = parseHostList(GetEnvValue(ctx, "OUTBOUND_ALLOW", ""))
+	p.denyHosts, p.denyNets = parseHostList(GetEnvValue(ctx, "OUTBOUND_DENY", ""))
+	return p
+}
+
+// parseHostList splits a comma separated list into host names and address ranges, a single IP is a range of one
+func parseHostList(list string) ([]string, []*net.IPNet) {
+	var hosts []string
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			if _, n, err := net.ParseCIDR(entry); err == nil {
+				nets = append(nets, n)
+			}
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			hosts = append(hosts, strings.TrimPrefix(entry, "*"))
+		}
+	}
+	return hosts, nets
+}
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	_, nets := parseHostList(strings.Join(cidrs, ","))
+	return nets
+}
+
+// dialContext checks the host name before dialing and every address it resolves to while dialing
+func (p *outboundPolicy) dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hostAllowed, err := p.checkHost(strings.ToLower(host))
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return p.checkIP(net.ParseIP(ip), hostAllowed)
+		},
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// checkHost refuses denied names and, with an allowlist, names not on it unless they are an IP.
+// It reports if the name itself is allowed, then its addresses needn't be on the allowlist.
+func (p *outboundPolicy) checkHost(host string) (bool, error) {
+	if matchHost(host, p.denyHosts) {
+		return false, fmt.Errorf("%s: %w", host, ErrOutboundBlocked)
+	}
+	if matchHost(host, p.allowHosts) {
+		return true, nil
+	}
+	if len(p.allowHosts) > 0 && len(p.allowNets) == 0 {
+		return false, fmt.Errorf("%s is not in OUTBOUND_ALLOW: %w", host, ErrOutboundBlocked)
+	}
+	return false, nil
+}
+
+// checkIP refuses denied & private addresses and, with an allowlist, addresses outside of it
+func (p *outboundPolicy) checkIP(ip net.IP, hostAllowed bool) error {
+	if ip == nil {
+		return ErrOutboundBlocked
+	}
+	if matchIP(ip, p.denyNets) {
+		return fmt.Errorf("%s: %w", ip, ErrOutboundBlocked)
+	}
+
+	inAllowed := matchIP(ip, p.allowNets)
+	if !hostAllowed && !inAllowed && (len(p.allowHosts) > 0 || len(p.allowNets) > 0) {
+		return fmt.Errorf("%s is not in OUTBOUND_ALLOW: %w", ip, ErrOutboundBlocked)
+	}
+	if isPrivateIP(ip) && !p.allowPrivate && !inAllowed {
+		return fmt.Errorf("%s is a private address, set OUTBOUND_ALLOW_PRIVATE=ON to reach it: %w", ip, ErrOutboundBlocked)
+	}
+	return nil
+}
+
+// matchHost matches a name exactly, entries starting with a dot (or *.) also match their subdomains
+func matchHost(host string, hosts []string) bool {
+	host = strings.TrimSuffix(host, ".")
+	for _, h := range hosts {
+		if host == h || strings.HasPrefix(h, ".") && (strings.HasSuffix(host, h) || host == h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchIP(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || matchIP(ip, reservedNets)
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zin-engine/model"
+)
+
+func outboundSite(env map[string]string) *model.RequestContext {
+	return &model.RequestContext{Context: context.Background(), ENV: env}
+}
+
+func TestCheckIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"127.8.9.10", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"0.0.0.0", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"64:ff9b::7f00:1", true},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+
+	policy := loadOutboundPolicy(outboundSite(map[string]string{}))
+	for _, tt := range tests {
+		err := policy.checkIP(net.ParseIP(tt.ip), false)
+		if blocked := errors.Is(err, ErrOutboundBlocked); blocked != tt.blocked {
+			t.Errorf("checkIP(%s) = %v, want blocked %v", tt.ip, err, tt.blocked)
+		}
+	}
+
+	open := loadOutboundPolicy(outboundSite(map[string]string{"OUTBOUND_ALLOW_PRIVATE": "ON"}))
+	if err := open.checkIP(net.ParseIP("169.254.169.254"), false); err != nil {
+		t.Errorf("OUTBOUND_ALLOW_PRIVATE=ON should reach link-local addresses: %v", err)
+	}
+}
+
+func TestCheckOutboundURL(t *testing.T) {
+	tests := []struct {
+		env     map[string]string
+		url     string
+		blocked bool
+	}{
+		{map[string]string{}, "https://api.example.com/posts", false},
+		{map[string]string{}, "http://127.0.0.1:8080/", true},
+		{map[string]string{}, "http://[::1]/", true},
+		{map[string]string{}, "http://169.254.169.254/latest/meta-data/", true},
+		{map[string]string{"OUTBOUND_DENY": "*.example.com"}, "https://api.example.com/", true},
+		{map[string]string{"OUTBOUND_DENY": "*.example.com"}, "https://API.Example.com./", true},
+		{map[string]string{"OUTBOUND_DENY": "*.example.com"}, "https://example.org/", false},
+		{map[string]string{"OUTBOUND_ALLOW": "api.example.com"}, "https://api.example.com/", false},
+		{map[string]string{"OUTBOUND_ALLOW": "api.example.com"}, "https://www.example.com/", true},
+		{map[string]string{"OUTBOUND_ALLOW": "10.0.0.0/8"}, "http://10.1.2.3/", false},
+		{map[string]string{"OUTBOUND_ALLOW": "10.0.0.0/8"}, "http://192.168.1.1/", true},
+	}
+	for _, tt := range tests {
+		err := CheckOutboundURL(outboundSite(tt.env), tt.url)
+		if blocked := errors.Is(err, ErrOutboundBlocked); blocked != tt.blocked {
+			t.Errorf("CheckOutboundURL(%s) with %v = %v, want blocked %v", tt.url, tt.env, err, tt.blocked)
+		}
+	}
+
+	for _, bad := range []string{"file:///etc/passwd", "gopher://example.com/", "http:///path", "://"} {
+		if err := CheckOutboundURL(outboundSite(map[string]string{}), bad); err == nil {
+			t.Errorf("CheckOutboundURL(%s) should be refused", bad)
+		}
+	}
+}
+
+func TestOutboundClientChecksDialedAddress(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer api.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(api.URL, "http://"))
+
+	redirect := httptest.NewServer(http.RedirectHandler("http://127.0.0.2:"+port, http.StatusFound))
+	defer redirect.Close()
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		url     string
+		blocked bool
+	}{
+		{"loopback literal", map[string]string{}, api.URL, true},
+		// A name passes the early check, the address it resolves to is what gets refused
+		{"name resolving to loopback", map[string]string{}, "http://localhost:" + port, true},
+		{"name allowed by pattern, address private", map[string]string{"OUTBOUND_ALLOW": "localhost"}, "http://localhost:" + port, true},
+		{"redirect off the allowlist", map[string]string{"OUTBOUND_ALLOW": "127.0.0.1"}, redirect.URL, true},
+		{"private allowed", map[string]string{"OUTBOUND_ALLOW_PRIVATE": "ON"}, "http://localhost:" + port, false},
+		{"range allowed", map[string]string{"OUTBOUND_ALLOW": "127.0.0.0/8"}, api.URL, false},
+	}
+	for _, tt := range tests {
+		if err := CheckOutboundURL(outboundSite(tt.env), tt.url); err != nil && !tt.blocked {
+			t.Errorf("%s: early check refused %s: %v", tt.name, tt.url, err)
+		}
+
+		resp, err := OutboundClient(outboundSite(tt.env)).Get(tt.url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if blocked := errors.Is(err, ErrOutboundBlocked); blocked != tt.blocked {
+			t.Errorf("%s: GET %s = %v, want blocked %v", tt.name, tt.url, err, tt.blocked)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"zin-engine/model"
+)
+
+// ErrOutsideRoot is returned for a path that would leave the site root
+var ErrOutsideRoot = errors.New("path leads outside of the site root")
+
+// ResolveSitePath turns a site relative path (request path, zin-include file, file:// source) into a file path.
+// Paths leaving the root are refused and logged. With STRICT_SYMLINKS=ON in .env, links pointing outside it are too.
+func ResolveSitePath(ctx *model.RequestContext, rel string) (string, error) {
+	strict := GetEnvValue(ctx, "STRICT_SYMLINKS", "OFF") == "ON"
+	path, err := SafeJoin(ctx.Root, rel, strict)
+	if err != nil {
+		fmt.Printf(">> Refused path '%s' of %s: %v\n", rel, ctx.Root, err)
+	}
+	return path, err
+}
+
+// SafeJoin joins rel onto root and makes sure the result stays inside root
+func SafeJoin(root string, rel string, strictSymlinks bool) (string, error) {
+	if strings.ContainsRune(rel, 0) {
+		return "", ErrOutsideRoot
+	}
+
+	root = filepath.Clean(root)
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if !withinRoot(root, path) {
+		return "", ErrOutsideRoot
+	}
+
+	if strictSymlinks {
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return "", fmt.Errorf("unable to resolve site root: %v", err)
+		}
+		if real := existingTarget(path); !withinRoot(realRoot, real) {
+			return "", fmt.Errorf("%w through a symlink", ErrOutsideRoot)
+		}
+	}
+
+	return path, nil
+}
+
+func withinRoot(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// existingTarget resolves the links of path, a missing file is resolved through its closest existing parent
+func existingTarget(path string) string {
+	missing := ""
+	for {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(real, missing)
+		} else if !os.IsNotExist(err) {
+			return ""
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		missing = filepath.Join(filepath.Base(path), missing)
+		path = parent
+	}
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"zin-engine/model"
+)
+
+// sqliteSite creates a site whose .env declares the sqlite connection "main"
+func sqliteSite(t *testing.T) *model.RequestContext {
+	t.Helper()
+	root := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(root, "site.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE posts (id INTEGER); INSERT INTO posts VALUES (1), (2), (3)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	return &model.RequestContext{
+		Context: context.Background(),
+		Root:    root,
+		ENV:     map[string]string{"DB_main_DSN": "sqlite://site.db"},
+	}
+}
+
+func TestGetConnectionSharesOnePool(t *testing.T) {
+	ctx := sqliteSite(t)
+
+	conns := make([]*SQLConn, 16)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := GetConnection(ctx, "main")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+
+	for _, conn := range conns {
+		if conn != conns[0] {
+			t.Fatal("concurrent requests of a site should end up with the same pool")
+		}
+	}
+}
+
+func TestGetConnectionDrainsReplacedPool(t *testing.T) {
+	defer func(grace time.Duration) { sqlRetireGrace = grace }(sqlRetireGrace)
+	sqlRetireGrace = 10 * time.Millisecond
+
+	ctx := sqliteSite(t)
+	old, err := GetConnection(ctx, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request is still reading from the pool when the .env changes
+	rows, err := old.DB.Query(`SELECT id FROM posts`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.ENV["DB_main_MAX_OPEN"] = "3"
+	conn, err := GetConnection(ctx, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == old {
+		t.Fatal("changed settings should open a new pool")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil || count != 3 {
+		t.Fatalf("the old pool was closed under a running query: %d rows, %v", count, err)
+	}
+	rows.Close()
+
+	// Once its connections are back the old pool is closed
+	deadline := time.Now().Add(5 * time.Second)
+	for old.DB.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the replaced pool was never closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := conn.DB.Ping(); err != nil {
+		t.Fatalf("the new pool should work: %v", err)
+	}
+}
+
+func TestSqliteDSNStaysInRoot(t *testing.T) {
+	outside := t.TempDir()
+	ctx := &model.RequestContext{Root: t.TempDir(), ENV: map[string]string{}}
+	if err := os.Symlink(outside, filepath.Join(ctx.Root, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		strict string
+		want   string // file relative to the root, empty when refused
+	}{
+		{"data/site.db", "OFF", "data/site.db"},
+		{"file:data/site.db?cache=shared", "OFF", "data/site.db"},
+		{"./site.db", "OFF", "site.db"},
+		{filepath.Join(ctx.Root, "data", "site.db"), "OFF", "data/site.db"},
+		{"../site.db", "OFF", ""},
+		{"data/../../../etc/x.db", "OFF", ""},
+		{filepath.Join(outside, "site.db"), "OFF", ""},
+		{"linked/site.db", "OFF", "linked/site.db"},
+		{"linked/site.db", "ON", ""},
+	}
+
+	for _, tt := range tests {
+		ctx.ENV["STRICT_SYMLINKS"] = tt.strict
+		got, err := sqliteDSN(ctx, tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("sqliteDSN(%q) with STRICT_SYMLINKS=%s = %q, want it refused", tt.path, tt.strict, got)
+			}
+			continue
+		}
+		want := "file:" + filepath.Join(ctx.Root, tt.want) + "?mode=ro&_pragma=query_only(1)"
+		if err != nil || got != want {
+			t.Errorf("sqliteDSN(%q) = %q, %v, want %q", tt.path, got, err, want)
+		}
+	}
+}
+
+func TestRunQueryTruncatesAtMaxRows(t *testing.T) {
+	ctx := sqliteSite(t)
+	ctx.CustomVar = model.CustomVar{Raw: map[string]string{}, LIST: map[string][]any{}}
+	conn, err := GetConnection(ctx, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxRows   int
+		rows      int
+		truncated string
+	}{
+		{2, 2, "true"},
+		{3, 3, "false"},
+		{10, 3, "false"},
+	}
+	for _, tt := range tests {
+		if err := RunQuery(ctx, conn, `SELECT id FROM posts ORDER BY id`, nil, tt.maxRows, "posts"); err != nil {
+			t.Fatalf("max-rows %d: %v", tt.maxRows, err)
+		}
+		if got := len(ctx.CustomVar.LIST["posts"]); got != tt.rows {
+			t.Errorf("max-rows %d kept %d rows, want %d", tt.maxRows, got, tt.rows)
+		}
+		if got := ctx.CustomVar.Raw["posts"+TruncatedSuffix]; got != tt.truncated {
+			t.Errorf("max-rows %d: posts_truncated = %q, want %q", tt.maxRows, got, tt.truncated)
+		}
+	}
+}
+
+func TestConvertSQLValue(t *testing.T) {
+	tests := []struct {
+		typeName string
+		value    any
+		want     any
+	}{
+		{"DECIMAL", []byte("19.990"), "19.990"},
+		{"NUMERIC", []byte("12345678901234567890.12"), "12345678901234567890.12"},
+		{"decimal", "0.10", "0.10"},
+		{"UNSIGNED DECIMAL", []byte("7"), "7"},
+		{"FLOAT", []byte("1.5"), 1.5},
+		{"DOUBLE", []byte("2"), 2.0},
+		{"BIGINT", []byte("42"), int64(42)},
+		{"UNSIGNED BIGINT", []byte("18446744073709551615"), uint64(18446744073709551615)},
+		{"BOOLEAN", []byte("TRUE"), true},
+		{"TINYINT", int64(3), int64(3)},
+		{"BOOL", int64(0), false},
+		{"VARCHAR", []byte("007"), "007"},
+		{"TEXT", nil, nil},
+	}
+	for _, tt := range tests {
+		got, err := convertSQLValue(tt.typeName, tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("convertSQLValue(%s, %v) = %#v, %v, want %#v", tt.typeName, tt.value, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of date & time columns returned as text (MySQL without parseTime, SQLite)
+var sqlTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02",
+}
+
+// convertSQLValue gives a scanned value the Go type of its column: int64, float64, bool,
+// time.Time, decoded JSON, or string. Drivers return many of them as []byte.
+// DECIMAL & NUMERIC stay the exact text sent by the database, e.g. "19.99".
+func convertSQLValue(typeName string, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	typeName = strings.ToUpper(typeName)
+	text, isText := value.([]byte)
+	if !isText {
+		if s, ok := value.(string); ok {
+			text, isText = []byte(s), true
+		}
+	}
+	if !isText {
+		// Already typed by the driver
+		if i, ok := value.(int64); ok && isBoolColumn(typeName) {
+			return i != 0, nil
+		}
+		return value, nil
+	}
+
+	s := string(text)
+	switch {
+	case isBoolColumn(typeName):
+		return strconv.ParseBool(strings.ToLower(s))
+	case isIntColumn(typeName):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		// Unsigned BIGINT beyond int64
+		return strconv.ParseUint(s, 10, 64)
+	case isDecimalColumn(typeName):
+		// Exact values, money & the like, a float64 would round them
+		return s, nil
+	case isFloatColumn(typeName):
+		return strconv.ParseFloat(s, 64)
+	case typeName == "JSON" || typeName == "JSONB":
+		var decoded any
+		if err := json.Unmarshal(text, &decoded); err != nil {
+			return nil, fmt.Errorf("invalid json: %v", err)
+		}
+		return decoded, nil
+	case typeName == "DATE" || typeName == "DATETIME" || strings.HasPrefix(typeName, "TIMESTAMP"):
+		for _, layout := range sqlTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		// Zero dates like 0000-00-00 stay text
+		return s, nil
+	}
+	return s, nil
+}
+
+func isBoolColumn(typeName string) bool {
+	return typeName == "BOOL" || typeName == "BOOLEAN"
+}
+
+func isIntColumn(typeName string) bool {
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "INT2", "INT4", "INT8", "SERIAL", "BIGSERIAL", "YEAR":
+		return true
+	}
+	return false
+}
+
+func isFloatColumn(typeName string) bool {
+	switch typeName {
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8", "DOUBLE PRECISION":
+		return true
+	}
+	return false
+}
+
+func isDecimalColumn(typeName string) bool {
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "DECIMAL", "NUMERIC", "DEC", "MONEY":
+		return true
+	}
+	return false
+}
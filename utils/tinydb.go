@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"zin-engine/model"
@@ -36,8 +37,8 @@ func GetValue(ctx *model.RequestContext, key string, defaultValue string, includ
 	}
 
 	// Find key in query-params of current request
-	if val, ok := ctx.Query[key]; ok {
-		return val[0]
+	if val, ok := QueryValue(ctx, key); ok {
+		return val
 	}
 
 	// If env allowed find key in env too
@@ -112,3 +113,106 @@ func resolveJSON(data map[string]interface{}, path []string) interface{} {
 	}
 	return current
 }
+
+// GetEnvValue reads a site setting from .env only, request values can't override it
+func GetEnvValue(ctx *model.RequestContext, key string, defaultValue string) string {
+	if val, ok := ctx.ENV[key]; ok && val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+// QueryValue finds a query param of the request. Looking it up is noted even when missing,
+// a cached route varies on every param its page read.
+func QueryValue(ctx *model.RequestContext, key string) (string, bool) {
+	if ctx.QueryReads != nil {
+		ctx.QueryReads[key] = true
+	}
+	if val, ok := ctx.Query[key]; ok && len(val) > 0 {
+		return val[0], true
+	}
+	return "", false
+}
+
+// QueryValues gives every query param, the page then varies on the whole query
+func QueryValues(ctx *model.RequestContext) url.Values {
+	if ctx.QueryReads != nil {
+		ctx.QueryReads["*"] = true
+	}
+	return ctx.Query
+}
+
+// LookupValue finds key like GetValue does but keeps the value type and reports if it exists.
+// Bare JSON & LIST names resolve to the whole object or list.
+func LookupValue(ctx *model.RequestContext, key string, includeEnv bool) (any, bool) {
+
+	// Find key in default vars
+	switch key {
+	case "ClientIp":
+		return ctx.ClientIp, true
+	case "Method":
+		return ctx.Method, true
+	case "Host":
+		return ctx.Host, true
+	case "Path":
+		return ctx.Path, true
+	}
+
+	if val, ok := ctx.LocalVar[key]; ok {
+		return val, true
+	}
+	if val, ok := ctx.CustomVar.Raw[key]; ok {
+		return val, true
+	}
+	if val, ok := QueryValue(ctx, key); ok {
+		return val, true
+	}
+	if includeEnv {
+		if val, ok := ctx.ENV[key]; ok {
+			return val, true
+		}
+	}
+
+	parts := SplitKeyPath(key)
+	if len(parts) == 0 {
+		return nil, false
+	}
+
+	if data, ok := ctx.CustomVar.JSON[parts[0]]; ok {
+		return ResolvePath(data, parts[1:])
+	}
+	if list, ok := ctx.CustomVar.LIST[parts[0]]; ok {
+		return ResolvePath(list, parts[1:])
+	}
+
+	return nil, false
+}
+
+// SplitKeyPath splits user.name or users[1].name into user, name or users, [1], name
+func SplitKeyPath(key string) []string {
+	return parseKeyParts(key)
+}
+
+// ResolvePath walks maps by key and lists by [n] index
+func ResolvePath(value any, path []string) (any, bool) {
+	current := value
+	for _, part := range path {
+		switch v := current.(type) {
+		case map[string]any:
+			val, ok := v[strings.Trim(part, "[]")]
+			if !ok {
+				return nil, false
+			}
+			current = val
+		case []any:
+			idx, err := strconv.Atoi(strings.Trim(part, "[]"))
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			current = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}